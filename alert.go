@@ -0,0 +1,50 @@
+package hashcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// hitRatioSamples is the number of reads the hit ratio alert waits for before
+// working out the hit ratio, so a handful of misses can't set it off.
+const hitRatioSamples = 100
+
+// SetHitRatioAlert makes each scavenge work out the hit ratio of the reads
+// since the last check, and call fn with it if it is below threshold.
+// Reads are carried over to the next scavenge until there are at least 100 of
+// them, so on a quiet cache the ratio covers more than one scavenge.
+// fn is called on the scavenger without holding any lock.
+// Passing a nil fn removes the alert.
+func (c *Cache) SetHitRatioAlert(threshold float64, fn func(ratio float64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alertBelow = threshold
+	c.alertFn = fn
+	c.alertHits = atomic.LoadUint64(&c.hits)
+	c.alertMisses = atomic.LoadUint64(&c.misses)
+}
+
+// checkHitRatio returns a call to the hit ratio alert if enough reads have been
+// made since the last check and their hit ratio is below the threshold, or nil
+// if not. The caller must hold the write lock.
+func (c *Cache) checkHitRatio() func() {
+	if c.alertFn == nil {
+		return nil
+	}
+	hits, misses := atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+	dh, dm := hits-c.alertHits, misses-c.alertMisses
+	if dh+dm < hitRatioSamples {
+		return nil
+	}
+	c.alertHits, c.alertMisses = hits, misses
+	ratio := float64(dh) / float64(dh+dm)
+	if ratio >= c.alertBelow {
+		return nil
+	}
+	fn := c.alertFn
+	return func() {
+		start := time.Now()
+		fn(ratio)
+		c.timeCallback("hit ratio alert", start)
+	}
+}
@@ -0,0 +1,51 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHitRatioAlert(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Drive the scavenge passes by hand
+	var ratios []float64
+	c.SetHitRatioAlert(0.5, func(ratio float64) { ratios = append(ratios, ratio) })
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	pass := func() {
+		if _, alert := c.scavengePass(uint64(time.Now().UnixNano()/1e6), nil); alert != nil {
+			alert()
+		}
+	}
+
+	for i := 0; i < 50; i++ {
+		c.Read([]byte("missing"))
+	}
+	pass()
+	if len(ratios) != 0 {
+		t.Fatalf("alert fired after %d reads, fewer than %d", 50, hitRatioSamples)
+	}
+	for i := 0; i < 25; i++ {
+		c.Read([]byte("k"))
+		c.Read([]byte("missing"))
+	}
+	pass() // 25 hits and 75 misses, carried over from the last pass
+	if len(ratios) != 1 || ratios[0] != 0.25 {
+		t.Fatalf("alert calls = %v, want [0.25]", ratios)
+	}
+	for i := 0; i < 100; i++ {
+		c.Read([]byte("k"))
+	}
+	pass()
+	if len(ratios) != 1 {
+		t.Errorf("alert fired for a hit ratio of 1: %v", ratios)
+	}
+
+	c.SetHitRatioAlert(0, nil)
+	for i := 0; i < 100; i++ {
+		c.Read([]byte("missing"))
+	}
+	pass()
+	if len(ratios) != 1 {
+		t.Errorf("alert fired after it was removed: %v", ratios)
+	}
+}
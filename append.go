@@ -0,0 +1,49 @@
+package hashcache
+
+import "fmt"
+
+// SetMaxValueSize sets the longest value, in bytes, which can be written to
+// the cache. Longer writes fail with ErrValueTooLarge, and Write drops them.
+// A size of 0 removes the limit. Values already stored aren't affected.
+func (c *Cache) SetMaxValueSize(n int) error {
+	if n < 0 {
+		return fmt.Errorf("maximum value size must not be negative")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxValueSize = n
+	return nil
+}
+
+// Append will add data to the end of the value of key under the write lock,
+// writing data as the value if the key is missing or reserved, and return
+// the new length of the value. Like a write, it restarts the entry's TTL,
+// but an entry with its own TTL keeps it.
+// It returns ErrValueTooLarge, leaving the value unchanged, if the result
+// would be longer than the maximum value size, or ErrCacheFull if the key is
+// new and the cache is full and rejects writes.
+func (c *Cache) Append(key, data []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old []byte
+	var ttl uint64
+	l := c.find(key)
+	if l != nil && !l.reserved {
+		old, ttl = *l.valuePointer, l.ttl
+	}
+	v := make([]byte, len(old)+len(data))
+	copy(v, old)
+	copy(v[len(old):], data)
+	l, err := c.insert(Row{K: key, V: v})
+	if err != nil {
+		return 0, err
+	}
+	l.ttl = ttl
+	return len(v), nil
+}
+
+// fits reports whether a value of n bytes is within the maximum value size.
+// The caller must hold the read or write lock.
+func (c *Cache) fits(n int) bool {
+	return c.maxValueSize == 0 || n <= c.maxValueSize
+}
@@ -0,0 +1,75 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppend(t *testing.T) {
+	c := newTestCache(t)
+	k := []byte("log")
+	for i, tt := range []struct {
+		data string
+		want string
+	}{
+		{"a", "a"},
+		{"bc", "abc"},
+		{"", "abc"},
+		{"def", "abcdef"},
+	} {
+		n, err := c.Append(k, []byte(tt.data))
+		if err != nil || n != len(tt.want) {
+			t.Errorf("append %d: Append(%q) = %d, %v, want %d", i, tt.data, n, err, len(tt.want))
+		}
+		if v, _ := c.Read(k); string(v) != tt.want {
+			t.Errorf("append %d: Read = %q, want %q", i, v, tt.want)
+		}
+	}
+	c.WriteWithTimer([]byte("timed"), []byte("x"), time.Hour, nil)
+	if _, err := c.Append([]byte("timed"), []byte("y")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := c.Describe([]byte("timed")); time.Until(info.Expires) < 50*time.Minute {
+		t.Errorf("entry expires at %v after appending, want its TTL of an hour kept", info.Expires)
+	}
+	c.Reserve([]byte("reserved"), time.Minute)
+	if n, err := c.Append([]byte("reserved"), []byte("new")); err != nil || n != 3 {
+		t.Errorf("Append to a reservation = %d, %v, want 3", n, err)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetMaxValueSize(-1); err == nil {
+		t.Error("SetMaxValueSize(-1) succeeded")
+	}
+	c.Write(Row{K: []byte("long"), V: []byte("0123456789")})
+	if err := c.SetMaxValueSize(5); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Read([]byte("long")); string(v) != "0123456789" {
+		t.Errorf("Read(long) = %q, want the value stored before the limit", v)
+	}
+	if err := c.WriteErr(Row{K: []byte("k"), V: []byte("123456")}); err != ErrValueTooLarge {
+		t.Errorf("WriteErr of 6 bytes = %v, want ErrValueTooLarge", err)
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("123456")})
+	if c.Has([]byte("k")) {
+		t.Error("Write of a value over the limit stored it")
+	}
+	if _, err := c.Append([]byte("k"), []byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.Append([]byte("k"), []byte("6")); err != ErrValueTooLarge || n != 0 {
+		t.Errorf("Append past the limit = %d, %v, want ErrValueTooLarge", n, err)
+	}
+	if v, _ := c.Read([]byte("k")); string(v) != "12345" {
+		t.Errorf("Read(k) = %q after a failed Append, want it unchanged", v)
+	}
+	if err := c.SetMaxValueSize(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteErr(Row{K: []byte("k"), V: make([]byte, 1<<20)}); err != nil {
+		t.Errorf("WriteErr of 1MiB with no limit = %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package hashcache
+
+import "bytes"
+
+// OpType is the kind of operation performed by an Op.
+type OpType int
+
+const (
+	// OpWrite writes Row, overwriting the key if it already exists.
+	OpWrite OpType = iota
+	// OpDelete removes the key Row.K.
+	OpDelete
+	// OpTouch resets the access time of the key Row.K, extending its TTL.
+	OpTouch
+	// OpCAS writes Row only if the key currently holds the value Old.
+	OpCAS
+)
+
+// Op is a single operation in a batch passed to Apply.
+type Op struct {
+	Type OpType
+	Row  Row
+	Old  []byte // Only used by OpCAS
+}
+
+// OpResult is the outcome of an Op.
+// OK is true for OpWrite unless the cache is full and rejects writes or the
+// value is too large, true for OpDelete and OpTouch if the key was found, and
+// true for OpCAS if the value was swapped.
+type OpResult struct {
+	OK bool
+}
+
+// Apply performs ops in order under a single write lock, so no other
+// operation on the cache observes the batch partially applied.
+// It returns a result for each op.
+func (c *Cache) Apply(ops []Op) []OpResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case OpWrite:
+			_, err := c.insert(op.Row)
+			results[i].OK = err == nil
+		case OpDelete:
+			if l := c.find(op.Row.K); l != nil {
+				c.deleteNode(l.tail)
+				results[i].OK = true
+			}
+		case OpTouch:
+			if l := c.find(op.Row.K); l != nil {
+				c.refresh(l)
+				results[i].OK = true
+			}
+		case OpCAS:
+			if l := c.find(op.Row.K); l != nil && !l.reserved && bytes.Equal(*l.valuePointer, op.Old) && c.fits(len(op.Row.V)) {
+				c.notify(c.setLeaf(l.tail, op.Row))
+				results[i].OK = true
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,53 @@
+package hashcache
+
+import "testing"
+
+func TestApply(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("deleted"), V: []byte("v")})
+	c.Write(Row{K: []byte("swapped"), V: []byte("old")})
+	c.Write(Row{K: []byte("kept"), V: []byte("old")})
+	results := c.Apply([]Op{
+		{Type: OpWrite, Row: Row{K: []byte("written"), V: []byte("v")}},
+		{Type: OpDelete, Row: Row{K: []byte("deleted")}},
+		{Type: OpDelete, Row: Row{K: []byte("missing")}},
+		{Type: OpTouch, Row: Row{K: []byte("written")}},
+		{Type: OpTouch, Row: Row{K: []byte("missing")}},
+		{Type: OpCAS, Row: Row{K: []byte("swapped"), V: []byte("new")}, Old: []byte("old")},
+		{Type: OpCAS, Row: Row{K: []byte("kept"), V: []byte("new")}, Old: []byte("other")},
+		{Type: OpCAS, Row: Row{K: []byte("missing"), V: []byte("new")}},
+	})
+	want := []bool{true, true, false, true, false, true, false, false}
+	for i, r := range results {
+		if r.OK != want[i] {
+			t.Errorf("result %d = %v, want %v", i, r.OK, want[i])
+		}
+	}
+	for k, want := range map[string]string{"written": "v", "swapped": "new", "kept": "old"} {
+		if v, _ := c.Read([]byte(k)); string(v) != want {
+			t.Errorf("Read(%q) = %q, want %q", k, v, want)
+		}
+	}
+	if c.Has([]byte("deleted")) || c.Has([]byte("missing")) {
+		t.Error("Apply left a deleted key, or wrote a missing one with OpCAS")
+	}
+}
+
+func TestApplyRejected(t *testing.T) {
+	c := newTestCache(t)
+	c.SetMaxEntries(1)
+	c.SetRejectOnFull(true)
+	c.SetMaxValueSize(2)
+	results := c.Apply([]Op{
+		{Type: OpWrite, Row: Row{K: []byte("a"), V: []byte("toolong")}},
+		{Type: OpWrite, Row: Row{K: []byte("a"), V: []byte("v")}},
+		{Type: OpWrite, Row: Row{K: []byte("b"), V: []byte("v")}},
+		{Type: OpCAS, Row: Row{K: []byte("a"), V: []byte("toolong")}, Old: []byte("v")},
+	})
+	want := []bool{false, true, false, false}
+	for i, r := range results {
+		if r.OK != want[i] {
+			t.Errorf("result %d = %v, want %v", i, r.OK, want[i])
+		}
+	}
+}
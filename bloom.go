@@ -0,0 +1,73 @@
+package hashcache
+
+import "fmt"
+
+// bloomHashes is the number of bits set in the bloom filter for each key.
+const bloomHashes = 4
+
+// bloom is a bloom filter of the hashes of the keys in the cache.
+// Bits can't be cleared, so deleted keys stay in the filter until it is
+// rebuilt, and stale counts the deletions since then.
+type bloom struct {
+	bits  []uint64
+	stale int
+}
+
+// SetBloomFilter enables a bloom filter of m bits, rounded down to a multiple
+// of 64, holding the hashes of the keys in the cache. It lets a lookup of a
+// missing key usually return without walking the trie, which speeds up
+// workloads dominated by misses.
+// The filter never gives a false negative. Its false positive rate rises with
+// the number of entries per bit, so m should be around 10 times the expected
+// number of entries for a rate of about 1%. Since deleted keys can't be removed
+// from the filter, it is rebuilt during a scavenge once enough entries have
+// been removed. Passing 0 disables the filter.
+func (c *Cache) SetBloomFilter(m uint64) error {
+	if m > 0 && m < 64 {
+		return fmt.Errorf("bloom filter must have at least 64 bits")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if m == 0 {
+		c.bloom = nil
+		return nil
+	}
+	c.bloom = &bloom{bits: make([]uint64, m/64)}
+	c.rebuildBloom()
+	return nil
+}
+
+// rebuildBloom clears the bloom filter and adds the hash of every key.
+// The caller must hold the write lock.
+func (c *Cache) rebuildBloom() {
+	c.bloom.reset()
+	for _, l := range c.tails {
+		c.bloom.add(c.hash(l.key))
+	}
+}
+
+func (b *bloom) reset() {
+	for i := range b.bits {
+		b.bits[i] = 0
+	}
+	b.stale = 0
+}
+
+func (b *bloom) add(h uint64) {
+	m := uint64(len(b.bits)) * 64
+	for i, h1, h2 := uint64(0), h&0xffffffff, h>>32; i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (b *bloom) has(h uint64) bool {
+	m := uint64(len(b.bits)) * 64
+	for i, h1, h2 := uint64(0), h&0xffffffff, h>>32; i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
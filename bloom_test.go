@@ -0,0 +1,81 @@
+package hashcache
+
+import "testing"
+
+func TestBloomFilter(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetBloomFilter(63); err == nil {
+		t.Error("SetBloomFilter accepted 63 bits")
+	}
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	if err := c.SetBloomFilter(10000); err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 1000; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	for i := 0; i < 1000; i++ {
+		if !c.Has(key(i)) {
+			t.Fatalf("the bloom filter hid %q", key(i))
+		}
+	}
+	c.mu.RLock()
+	positives := 0
+	for i := 1000; i < 11000; i++ {
+		if c.bloom.has(c.hash(key(i))) {
+			positives++
+		}
+	}
+	c.mu.RUnlock()
+	if positives > 500 {
+		t.Errorf("%d false positives in 10000 lookups, want about 1%%", positives)
+	}
+}
+
+func TestBloomFilterRebuild(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	c.SetBloomFilter(1024)
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	for i := 0; i < 50; i++ {
+		c.Delete(key(i))
+	}
+	waitFor(t, "the bloom filter to be rebuilt", func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.bloom.stale == 0
+	})
+	for i := 50; i < 100; i++ {
+		if !c.Has(key(i)) {
+			t.Fatalf("the rebuilt bloom filter hid %q", key(i))
+		}
+	}
+	c.SetBloomFilter(0)
+	if !c.Has(key(50)) {
+		t.Error("Has missed after disabling the bloom filter")
+	}
+}
+
+func BenchmarkReadMiss(b *testing.B) {
+	for _, bits := range []uint64{0, 10 * benchmarkKeys} {
+		name := "NoBloom"
+		if bits > 0 {
+			name = "Bloom"
+		}
+		b.Run(name, func(b *testing.B) {
+			c := benchmarkCache(b)
+			c.SetBloomFilter(bits)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Read(key(benchmarkKeys + i))
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package hashcache
+
+import (
+	"encoding/binary"
+
+	"github.com/dchest/siphash"
+)
+
+// ContentChecksum returns a checksum of the keys and values in the cache,
+// which is the same for any two caches holding the same entries, whatever
+// order they were written in and whatever their hash keys.
+// Reservations, access times and TTLs aren't included.
+func (c *Cache) ContentChecksum() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var sum uint64
+	var buf []byte
+	prefix := make([]byte, binary.MaxVarintLen64)
+	for _, l := range c.tails {
+		if l.reserved {
+			continue
+		}
+		n := binary.PutUvarint(prefix, uint64(len(l.key)))
+		buf = append(append(append(buf[:0], prefix[:n]...), l.key...), *l.valuePointer...)
+		sum ^= siphash.Hash(0, 0, buf)
+	}
+	return sum
+}
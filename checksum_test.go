@@ -0,0 +1,37 @@
+package hashcache
+
+import "testing"
+
+func TestContentChecksum(t *testing.T) {
+	a := newTestCache(t)
+	b := NewCache("another hash key")
+	defer b.Close()
+	if a.ContentChecksum() != b.ContentChecksum() {
+		t.Error("empty caches have different checksums")
+	}
+	for i := 0; i < 10; i++ {
+		a.Write(Row{K: key(i), V: key(i)})
+		b.Write(Row{K: key(9 - i), V: key(9 - i)})
+	}
+	b.Reserve([]byte("reserved"), 0)
+	sum := a.ContentChecksum()
+	if sum != b.ContentChecksum() {
+		t.Error("caches with the same entries have different checksums")
+	}
+	b.Write(Row{K: key(3), V: []byte("changed")})
+	if sum == b.ContentChecksum() {
+		t.Error("changing a value didn't change the checksum")
+	}
+	b.Write(Row{K: key(3), V: key(3)})
+	if sum != b.ContentChecksum() {
+		t.Error("restoring a value didn't restore the checksum")
+	}
+	// The length prefix keeps the key and value apart.
+	a.Reinitialize()
+	b.Reinitialize()
+	a.Write(Row{K: []byte("ab"), V: []byte("c")})
+	b.Write(Row{K: []byte("a"), V: []byte("bc")})
+	if a.ContentChecksum() == b.ContentChecksum() {
+		t.Error("moving bytes between the key and value didn't change the checksum")
+	}
+}
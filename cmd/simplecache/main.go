@@ -31,7 +31,7 @@ func main() {
 	}
 	// Add some data
 	for _, kv := range kvs {
-		c.Write(hashcache.Row{kv.key, kv.value})
+		c.Write(hashcache.Row{K: kv.key, V: kv.value})
 	}
 	// Read some data
 	for _, kv := range kvs {
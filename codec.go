@@ -0,0 +1,73 @@
+package hashcache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec converts values to and from bytes for WriteValue and ReadValue.
+// If ContentChecksum is used, Encode must be deterministic, so that equal
+// values always encode to the same bytes. Gob, for example, is not
+// deterministic for maps.
+type Codec struct {
+	Encode func(v interface{}) ([]byte, error)
+	Decode func(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values as JSON. It is the default codec.
+var JSONCodec = Codec{Encode: json.Marshal, Decode: json.Unmarshal}
+
+// GobCodec encodes values with encoding/gob. Each value is encoded with its
+// own type information, so it can be decoded on its own.
+var GobCodec = Codec{
+	Encode: func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	},
+	Decode: func(data []byte, v interface{}) error {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+	},
+}
+
+// SetCodec sets the codec used by WriteValue and ReadValue. A codec with a
+// nil Encode or Decode restores the default, JSONCodec.
+// Values written with one codec can't be read with another.
+func (c *Cache) SetCodec(codec Codec) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if codec.Encode == nil || codec.Decode == nil {
+		codec = JSONCodec
+	}
+	c.codec = codec
+}
+
+// WriteValue encodes v with the cache's codec and writes it to key.
+// It returns any error from encoding or from WriteErr.
+func (c *Cache) WriteValue(key []byte, v interface{}) error {
+	c.mu.RLock()
+	codec := c.codec
+	c.mu.RUnlock()
+	b, err := codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteErr(Row{K: key, V: b})
+}
+
+// ReadValue reads key and decodes its value into v, which must be a pointer,
+// with the cache's codec. It returns false if the key isn't in the cache, in
+// which case v is unchanged.
+func (c *Cache) ReadValue(key []byte, v interface{}) (bool, error) {
+	b, ok := c.Read(key)
+	if !ok {
+		return false, nil
+	}
+	c.mu.RLock()
+	codec := c.codec
+	c.mu.RUnlock()
+	return true, codec.Decode(b, v)
+}
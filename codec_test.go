@@ -0,0 +1,48 @@
+package hashcache
+
+import (
+	"errors"
+	"testing"
+)
+
+type codecValue struct {
+	Name  string
+	Count int
+}
+
+func TestCodecs(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec} {
+		c := newTestCache(t)
+		c.SetCodec(codec)
+		want := codecValue{Name: "n", Count: 3}
+		if err := c.WriteValue([]byte("k"), want); err != nil {
+			t.Fatalf("%s: WriteValue = %v", name, err)
+		}
+		var got codecValue
+		if ok, err := c.ReadValue([]byte("k"), &got); !ok || err != nil || got != want {
+			t.Errorf("%s: ReadValue = %v, %v, %+v", name, ok, err, got)
+		}
+		if ok, err := c.ReadValue([]byte("missing"), &got); ok || err != nil {
+			t.Errorf("%s: ReadValue of a missing key = %v, %v", name, ok, err)
+		}
+	}
+}
+
+func TestSetCodec(t *testing.T) {
+	c := newTestCache(t)
+	failed := errors.New("failed")
+	c.SetCodec(Codec{
+		Encode: func(interface{}) ([]byte, error) { return nil, failed },
+		Decode: func([]byte, interface{}) error { return failed },
+	})
+	if err := c.WriteValue([]byte("k"), 1); err != failed {
+		t.Errorf("WriteValue with a failing codec = %v", err)
+	}
+	c.SetCodec(Codec{})
+	if err := c.WriteValue([]byte("k"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Read([]byte("k")); string(v) != "1" {
+		t.Errorf("an empty Codec didn't restore JSONCodec, value = %q", v)
+	}
+}
@@ -0,0 +1,89 @@
+package hashcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LockWaits is how long callers have waited to acquire the cache lock.
+type LockWaits struct {
+	ReadTotal  time.Duration // Total wait for the read lock
+	ReadMax    time.Duration // Longest single wait for the read lock
+	WriteTotal time.Duration // Total wait for the write lock
+	WriteMax   time.Duration // Longest single wait for the write lock
+}
+
+// lock is the cache lock, which can time how long each caller waits for it.
+type lock struct {
+	readTotal  int64 // nanoseconds, first for 64 bit alignment of atomic access
+	readMax    int64
+	writeTotal int64
+	writeMax   int64
+	timed      int32 // accessed atomically
+	sync.RWMutex
+}
+
+// SetLockTiming sets whether the time spent waiting for the cache lock is
+// recorded for LockWaits. It is off by default, and costs a couple of clock
+// readings and a few atomic operations per lock when on.
+// A large total or maximum wait shows that the single lock is a bottleneck.
+func (c *Cache) SetLockTiming(enabled bool) {
+	var on int32
+	if enabled {
+		on = 1
+	}
+	atomic.StoreInt32(&c.mu.timed, on)
+}
+
+// LockWaits returns the time spent waiting for the cache lock while lock
+// timing was on.
+func (c *Cache) LockWaits() LockWaits {
+	return LockWaits{
+		ReadTotal:  time.Duration(atomic.LoadInt64(&c.mu.readTotal)),
+		ReadMax:    time.Duration(atomic.LoadInt64(&c.mu.readMax)),
+		WriteTotal: time.Duration(atomic.LoadInt64(&c.mu.writeTotal)),
+		WriteMax:   time.Duration(atomic.LoadInt64(&c.mu.writeMax)),
+	}
+}
+
+// Lock acquires the write lock, timing the wait if lock timing is on.
+func (l *lock) Lock() {
+	if atomic.LoadInt32(&l.timed) == 0 {
+		l.RWMutex.Lock()
+		return
+	}
+	start := time.Now()
+	l.RWMutex.Lock()
+	recordWait(&l.writeTotal, &l.writeMax, time.Since(start))
+}
+
+// RLock acquires the read lock, timing the wait if lock timing is on.
+func (l *lock) RLock() {
+	if atomic.LoadInt32(&l.timed) == 0 {
+		l.RWMutex.RLock()
+		return
+	}
+	start := time.Now()
+	l.RWMutex.RLock()
+	recordWait(&l.readTotal, &l.readMax, time.Since(start))
+}
+
+// reset zeroes the recorded waits.
+func (l *lock) reset() {
+	atomic.StoreInt64(&l.readTotal, 0)
+	atomic.StoreInt64(&l.readMax, 0)
+	atomic.StoreInt64(&l.writeTotal, 0)
+	atomic.StoreInt64(&l.writeMax, 0)
+}
+
+// recordWait adds wait to total, and raises max to wait if it is longer.
+func recordWait(total, max *int64, wait time.Duration) {
+	atomic.AddInt64(total, int64(wait))
+	for {
+		m := atomic.LoadInt64(max)
+		if int64(wait) <= m || atomic.CompareAndSwapInt64(max, m, int64(wait)) {
+			return
+		}
+	}
+}
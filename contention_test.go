@@ -0,0 +1,82 @@
+package hashcache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holdLock holds the write lock of c for d while reads and writes queue for it.
+func holdLock(c *Cache, d time.Duration) {
+	var wg sync.WaitGroup
+	c.mu.Lock()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.Read([]byte("k"))
+	}()
+	go func() {
+		defer wg.Done()
+		c.Write(Row{K: []byte("k"), V: []byte("v")})
+	}()
+	time.Sleep(d)
+	c.mu.Unlock()
+	wg.Wait()
+}
+
+func TestLockWaits(t *testing.T) {
+	c := newTestCache(t)
+	holdLock(c, 10*time.Millisecond)
+	if w := c.LockWaits(); w != (LockWaits{}) {
+		t.Errorf("LockWaits() = %+v with lock timing off, want zero", w)
+	}
+	c.SetLockTiming(true)
+	holdLock(c, 20*time.Millisecond)
+	w := c.LockWaits()
+	if w.ReadMax < 10*time.Millisecond || w.WriteMax < 10*time.Millisecond {
+		t.Errorf("LockWaits() = %+v after holding the lock for 20ms, want longer maximum waits", w)
+	}
+	if w.ReadTotal < w.ReadMax || w.WriteTotal < w.WriteMax {
+		t.Errorf("LockWaits() = %+v, want totals at least the maximums", w)
+	}
+	c.SetLockTiming(false)
+	holdLock(c, 30*time.Millisecond)
+	if after := c.LockWaits(); after.ReadMax != w.ReadMax || after.WriteMax != w.WriteMax {
+		t.Errorf("LockWaits() = %+v after turning lock timing off, want the maximums unchanged from %+v", after, w)
+	}
+}
+
+func TestLockWaitsExcludeWaitEmpty(t *testing.T) {
+	c := newTestCache(t)
+	c.SetLockTiming(true)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	done := make(chan error)
+	go func() { done <- c.WaitEmpty(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	// Wake the waiter while holding the lock, so it has to wait to get it back.
+	c.mu.Lock()
+	c.empty.Broadcast()
+	time.Sleep(30 * time.Millisecond)
+	c.mu.Unlock()
+	c.Delete([]byte("k"))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if w := c.LockWaits(); w.WriteMax >= 20*time.Millisecond {
+		t.Errorf("LockWaits() = %+v, want the wait for the cache to empty left out", w)
+	}
+}
+
+func TestReinitializeResetsLockWaits(t *testing.T) {
+	c := newTestCache(t)
+	c.SetLockTiming(true)
+	holdLock(c, 10*time.Millisecond)
+	if w := c.LockWaits(); w == (LockWaits{}) {
+		t.Fatal("LockWaits() = zero after holding the lock")
+	}
+	c.Reinitialize()
+	if w := c.LockWaits(); w != (LockWaits{}) {
+		t.Errorf("LockWaits() = %+v after Reinitialize, want zero", w)
+	}
+}
@@ -0,0 +1,53 @@
+package hashcache
+
+// CopyMode sets when the cache copies keys and values, trading safety
+// for performance.
+type CopyMode int
+
+const (
+	// CopyBoth copies keys and values when they are written and when they are
+	// read, so neither the caller nor the cache can see the other's changes
+	// to a slice. This is the default.
+	CopyBoth CopyMode = iota
+	// CopyRead only copies values and keys when they are read.
+	CopyRead
+	// CopyWrite only copies values and keys when they are written.
+	CopyWrite
+	// CopyNone never copies. Any change a caller makes to a slice it passed to
+	// Write, or received from Read, changes the cached data, and changing a
+	// key this way leaves its entry unreachable. Only use it when the slices
+	// are never modified after being handed over.
+	CopyNone
+)
+
+// SetCopyMode sets when the cache copies keys and values.
+// It only affects writes and reads made after it is called.
+func (c *Cache) SetCopyMode(m CopyMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.copyMode = m
+}
+
+// copyIn returns b, or a copy of b if the copy mode copies on write.
+// The caller must hold the write lock.
+func (c *Cache) copyIn(b []byte) []byte {
+	if c.copyMode == CopyBoth || c.copyMode == CopyWrite {
+		return copyBytes(b)
+	}
+	return b
+}
+
+// copyOut returns b, or a copy of b if the copy mode copies on read.
+// The caller must hold the read or write lock.
+func (c *Cache) copyOut(b []byte) []byte {
+	if c.copyMode == CopyBoth || c.copyMode == CopyRead {
+		return copyBytes(b)
+	}
+	return b
+}
+
+func copyBytes(b []byte) []byte {
+	cp := make([]byte, len(b))
+	copy(cp, b)
+	return cp
+}
@@ -0,0 +1,30 @@
+package hashcache
+
+import "testing"
+
+func TestCopyMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode                CopyMode
+		sharedIn, sharedOut bool
+	}{
+		{CopyBoth, false, false},
+		{CopyRead, true, false},
+		{CopyWrite, false, true},
+		{CopyNone, true, true},
+	} {
+		c := newTestCache(t)
+		c.SetCopyMode(tt.mode)
+		v := []byte("value")
+		c.Write(Row{K: []byte("k"), V: v})
+		v[0] = 'V'
+		got, _ := c.Read([]byte("k"))
+		if shared := got[0] == 'V'; shared != tt.sharedIn {
+			t.Errorf("mode %d: written value shared = %v, want %v", tt.mode, shared, tt.sharedIn)
+		}
+		got[1] = 'A'
+		again, _ := c.Read([]byte("k"))
+		if shared := again[1] == 'A'; shared != tt.sharedOut {
+			t.Errorf("mode %d: read value shared = %v, want %v", tt.mode, shared, tt.sharedOut)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package hashcache
+
+// WriteWithDeps will add the key and value to the cache, like Write, and
+// record that the value was derived from the entries for deps.
+// Writing, deleting or expiring any of deps then removes key from the cache,
+// along with anything derived from key in turn. Overwriting key replaces
+// its dependencies. Dependency cycles are allowed, and removal stops once
+// every entry in a cycle has been visited.
+func (c *Cache) WriteWithDeps(key, value []byte, deps ...[]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.insert(Row{K: key, V: value}); err != nil {
+		return
+	}
+	k := string(c.normalize(key))
+	for _, dep := range deps {
+		d := string(c.normalize(dep))
+		if c.dependents[d] == nil {
+			c.dependents[d] = map[string]bool{}
+		}
+		c.dependents[d][k] = true
+		c.dependsOn[k] = append(c.dependsOn[k], d)
+	}
+}
+
+// changed forgets the dependencies of key, and removes every entry derived
+// from it. The caller must hold the write lock.
+func (c *Cache) changed(key []byte) {
+	if len(c.dependsOn) == 0 {
+		return
+	}
+	k := string(key)
+	c.forgetDeps(k)
+	seen := map[string]bool{k: true}
+	queue := []string{k}
+	var stale []string
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		for dependent := range c.dependents[d] {
+			if !seen[dependent] {
+				seen[dependent] = true
+				queue = append(queue, dependent)
+				stale = append(stale, dependent)
+			}
+		}
+	}
+	for _, d := range stale {
+		c.forgetDeps(d)
+		if l := c.find([]byte(d)); l != nil {
+			c.deleteNode(l.tail)
+		}
+	}
+}
+
+// forgetDeps removes the record of the keys that k was derived from.
+// The caller must hold the write lock.
+func (c *Cache) forgetDeps(k string) {
+	for _, d := range c.dependsOn[k] {
+		delete(c.dependents[d], k)
+		if len(c.dependents[d]) == 0 {
+			delete(c.dependents, d)
+		}
+	}
+	delete(c.dependsOn, k)
+}
+
+// rekeyDeps replaces every key in the dependency records with the key passed
+// through fn, merging the records of keys which fn maps to the same bytes.
+// The caller must hold the write lock.
+func (c *Cache) rekeyDeps(fn func([]byte) []byte) {
+	dependents := make(map[string]map[string]bool, len(c.dependents))
+	dependsOn := make(map[string][]string, len(c.dependsOn))
+	for k, deps := range c.dependsOn {
+		n := string(fn([]byte(k)))
+		for _, d := range deps {
+			d = string(fn([]byte(d)))
+			if dependents[d] == nil {
+				dependents[d] = map[string]bool{}
+			}
+			dependents[d][n] = true
+			dependsOn[n] = append(dependsOn[n], d)
+		}
+	}
+	c.dependents, c.dependsOn = dependents, dependsOn
+}
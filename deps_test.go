@@ -0,0 +1,73 @@
+package hashcache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteWithDeps(t *testing.T) {
+	c := newTestCache(t)
+	write := func() {
+		c.Write(Row{K: []byte("parent"), V: []byte("v")})
+		c.WriteWithDeps([]byte("child"), []byte("v"), []byte("parent"))
+		c.WriteWithDeps([]byte("grandchild"), []byte("v"), []byte("child"))
+		c.Write(Row{K: []byte("other"), V: []byte("v")})
+	}
+	check := func(what string, want map[string]bool) {
+		t.Helper()
+		for k, has := range want {
+			if c.Has([]byte(k)) != has {
+				t.Errorf("after %s, Has(%q) = %v, want %v", what, k, !has, has)
+			}
+		}
+	}
+
+	write()
+	c.Write(Row{K: []byte("parent"), V: []byte("w")})
+	check("writing the parent", map[string]bool{"parent": true, "child": false, "grandchild": false, "other": true})
+
+	write()
+	c.Delete([]byte("parent"))
+	check("deleting the parent", map[string]bool{"parent": false, "child": false, "grandchild": false, "other": true})
+
+	write()
+	c.Delete([]byte("child"))
+	check("deleting the child", map[string]bool{"parent": true, "child": false, "grandchild": false, "other": true})
+
+	write()
+	c.WriteWithDeps([]byte("child"), []byte("v"), []byte("other"))
+	c.Write(Row{K: []byte("parent"), V: []byte("w")})
+	check("replacing the child's dependencies", map[string]bool{"child": true})
+	c.Delete([]byte("other"))
+	check("deleting the new dependency", map[string]bool{"child": false})
+}
+
+func TestWriteWithDepsCycle(t *testing.T) {
+	c := newTestCache(t)
+	c.WriteWithDeps([]byte("a"), []byte("v"), []byte("b"))
+	c.WriteWithDeps([]byte("b"), []byte("v"), []byte("a"))
+	if c.Has([]byte("a")) || !c.Has([]byte("b")) {
+		t.Fatal("writing b didn't remove a, which depends on it")
+	}
+	c.WriteWithDeps([]byte("a"), []byte("v"), []byte("b"))
+	if !c.Has([]byte("a")) || c.Has([]byte("b")) {
+		t.Fatal("writing a didn't remove b, which depends on it")
+	}
+	c.Delete([]byte("a"))
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.dependents) != 0 || len(c.dependsOn) != 0 {
+		t.Errorf("dependencies left behind: %v, %v", c.dependents, c.dependsOn)
+	}
+}
+
+func TestWriteWithDepsKeyNormalizer(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("Parent"), V: []byte("v")})
+	c.WriteWithDeps([]byte("Child"), []byte("v"), []byte("Parent"))
+	c.SetKeyNormalizer(bytes.ToLower)
+	c.Write(Row{K: []byte("PARENT"), V: []byte("w")})
+	if c.Has([]byte("child")) {
+		t.Error("an entry derived before SetKeyNormalizer survived a write of its dependency")
+	}
+}
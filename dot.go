@@ -0,0 +1,40 @@
+package hashcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// WriteDOT writes a Graphviz DOT representation of the trie to w, for debugging.
+// Each node is labelled with an id, edges are labelled with the nibble they
+// represent, and tail nodes are drawn as boxes if they hold a leaf, or in red
+// if they don't, which only happens while lazy pruning hasn't reclaimed them.
+// The read lock is held while the trie is walked.
+func (c *Cache) WriteDOT(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph hashcache {")
+	ids := map[*node]int{}
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		id := len(ids)
+		ids[n] = id
+		switch {
+		case depth < hashLen/bitsPerNode:
+			fmt.Fprintf(bw, "\tn%d [label=\"%d\"];\n", id, id)
+		case c.tails[n] != nil:
+			fmt.Fprintf(bw, "\tn%d [label=%q, shape=box];\n", id, c.tails[n].key)
+		default:
+			fmt.Fprintf(bw, "\tn%d [label=\"%d\", shape=box, color=red];\n", id, id)
+		}
+		n.each(func(i uint64, child *node) {
+			walk(child, depth+1)
+			fmt.Fprintf(bw, "\tn%d -> n%d [label=\"%x\"];\n", id, ids[child], i)
+		})
+	}
+	walk(c.head, 0)
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
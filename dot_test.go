@@ -0,0 +1,32 @@
+package hashcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("a"), V: []byte("1")})
+	c.Write(Row{K: []byte("b"), V: []byte("2")})
+	var buf bytes.Buffer
+	if err := c.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	if !strings.HasPrefix(dot, "digraph hashcache {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("WriteDOT output isn't a digraph:\n%s", dot)
+	}
+	for _, label := range []string{`label="a", shape=box`, `label="b", shape=box`} {
+		if !strings.Contains(dot, label) {
+			t.Errorf("WriteDOT output has no node %s", label)
+		}
+	}
+	if edges, nodes := strings.Count(dot, "->"), c.NodeCount(); edges != nodes-1 {
+		t.Errorf("WriteDOT output has %d edges for %d nodes", edges, nodes)
+	}
+	if strings.Contains(dot, "color=red") {
+		t.Error("WriteDOT output has an empty tail node")
+	}
+}
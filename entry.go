@@ -0,0 +1,170 @@
+package hashcache
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// EntryInfo is metadata about an entry in the cache.
+type EntryInfo struct {
+	Key      []byte
+	Size     int       // Length of the value
+	Created  time.Time // Time the key was first written, kept when it is overwritten
+	Accessed time.Time // Time of the last write or read
+	Accesses uint64    // Number of reads
+	Expires  time.Time // Time the entry expires unless it is refreshed, zero if never
+}
+
+// Describe returns the metadata of the entry for key, and true, or false if
+// the key isn't found or is only reserved. It looks the key up once, and
+// doesn't count as an access of it.
+func (c *Cache) Describe(key []byte) (EntryInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	if l == nil || l.reserved {
+		return EntryInfo{}, false
+	}
+	return c.info(l), true
+}
+
+// TopAccessed returns metadata for the n entries which have been read the
+// most, most read first. Reservations aren't included.
+func (c *Cache) TopAccessed(n int) []EntryInfo {
+	return c.topInfos(n, func(a, b EntryInfo) bool { return a.Accesses > b.Accesses })
+}
+
+// ExpiringSoon returns metadata for the n entries which will expire first,
+// soonest first, so they can be refreshed before they do. Entries which never
+// expire come last. Reservations aren't included.
+func (c *Cache) ExpiringSoon(n int) []EntryInfo {
+	return c.topInfos(n, func(a, b EntryInfo) bool {
+		return !a.Expires.IsZero() && (b.Expires.IsZero() || a.Expires.Before(b.Expires))
+	})
+}
+
+// ExpiryBuckets returns the number of entries expiring in each of the next
+// count windows of bucketSize, starting now, to show whether expiries are
+// spread out or bunched together. Entries which are already due to expire
+// are counted in the first window, and entries expiring after the last
+// window, or never, aren't counted. Reservations aren't included.
+// It returns nil if bucketSize or count isn't positive.
+func (c *Cache) ExpiryBuckets(bucketSize time.Duration, count int) []int {
+	if bucketSize <= 0 || count <= 0 {
+		return nil
+	}
+	buckets := make([]int, count)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	for _, l := range c.tails {
+		d := c.deadline(l)
+		if l.reserved || d == noExpiry {
+			continue
+		}
+		i := int(time.Unix(0, int64(d)*1e6).Sub(now) / bucketSize)
+		switch {
+		case i < 0:
+			buckets[0]++
+		case i < count:
+			buckets[i]++
+		}
+	}
+	return buckets
+}
+
+// TimeRange returns the times the oldest and newest entries in the cache were
+// first written, and true, or false if the cache holds no entries.
+// Overwriting an entry doesn't change when it was first written.
+// Reservations aren't included.
+func (c *Cache) TimeRange() (oldest, newest time.Time, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var min, max int64
+	for _, l := range c.tails {
+		if l.reserved {
+			continue
+		}
+		if !ok || l.created < min {
+			min = l.created
+		}
+		if !ok || l.created > max {
+			max = l.created
+		}
+		ok = true
+	}
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(0, min), time.Unix(0, max), true
+}
+
+// topInfos returns metadata for the first n entries in the order given by less.
+func (c *Cache) topInfos(n int, less func(a, b EntryInfo) bool) []EntryInfo {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	infos := make([]EntryInfo, 0, len(c.tails))
+	for _, l := range c.tails {
+		if !l.reserved {
+			infos = append(infos, c.info(l))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return less(infos[i], infos[j]) })
+	switch {
+	case n < 0:
+		infos = infos[:0]
+	case n < len(infos):
+		infos = infos[:n]
+	}
+	return infos
+}
+
+// info returns the metadata of l. It is safe to call under the read lock.
+func (c *Cache) info(l *leaf) EntryInfo {
+	info := EntryInfo{
+		Key:      c.copyOut(l.key),
+		Created:  time.Unix(0, l.created),
+		Accessed: time.Unix(0, int64(atomic.LoadUint64(&l.accessed))),
+		Accesses: atomic.LoadUint64(&l.accesses),
+	}
+	if d := c.deadline(l); d != noExpiry {
+		info.Expires = time.Unix(0, int64(d)*1e6)
+	}
+	if l.valuePointer != nil {
+		info.Size = len(*l.valuePointer)
+	}
+	return info
+}
+
+// BranchHistogram returns the number of entries beneath each child of the
+// top level node of the trie, indexed by the first nibble of their hash.
+// With a good hash the entries are spread evenly, so a skewed histogram points
+// to a bad hash or a pathological key pattern.
+func (c *Cache) BranchHistogram() []int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	branches := map[*node]int{}
+	c.head.each(func(i uint64, child *node) {
+		branches[child] = int(i)
+	})
+	histogram := make([]int, 1<<bitsPerNode)
+	for n := range c.tails {
+		for n.parent != c.head {
+			n = n.parent
+		}
+		histogram[branches[n]]++
+	}
+	return histogram
+}
+
+// BranchingFactor returns the maximum number of children of each node in the
+// trie, which is the length of the slice returned by BranchHistogram.
+func (c *Cache) BranchingFactor() int {
+	return 1 << bitsPerNode
+}
+
+// Depth returns the number of nodes below the head on the path to every entry.
+func (c *Cache) Depth() int {
+	return hashLen / bitsPerNode
+}
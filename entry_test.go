@@ -0,0 +1,211 @@
+package hashcache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopAccessed(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 4; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+		for j := 0; j < i; j++ {
+			c.Read(key(i))
+		}
+	}
+	c.Reserve([]byte("reserved"), 0)
+	top := c.TopAccessed(2)
+	if len(top) != 2 || string(top[0].Key) != "key-3" || string(top[1].Key) != "key-2" {
+		t.Fatalf("TopAccessed(2) = %v", top)
+	}
+	if top[0].Accesses != 3 || top[0].Size != 1 {
+		t.Errorf("TopAccessed(2)[0] = %+v, want 3 accesses of a 1 byte value", top[0])
+	}
+	top[0].Key[0] = 'X'
+	if !c.Has(key(3)) {
+		t.Error("changing a key returned by TopAccessed changed the cached key")
+	}
+	if all := c.TopAccessed(10); len(all) != 4 {
+		t.Errorf("TopAccessed(10) returned %d entries, want 4", len(all))
+	}
+	if none := c.TopAccessed(-1); len(none) != 0 {
+		t.Errorf("TopAccessed(-1) returned %d entries", len(none))
+	}
+}
+
+func TestBranchHistogram(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 200; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	histogram := c.BranchHistogram()
+	if len(histogram) != c.BranchingFactor() {
+		t.Fatalf("len(BranchHistogram()) = %d, want %d", len(histogram), c.BranchingFactor())
+	}
+	total := 0
+	for _, n := range histogram {
+		total += n
+	}
+	if total != 200 {
+		t.Errorf("BranchHistogram() counts %d entries, want 200", total)
+	}
+
+	c.Reinitialize()
+	if err := c.SetHashFunc(func(k []byte) uint64 { return uint64(k[len(k)-1])<<bitsPerNode | 5 }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	if histogram := c.BranchHistogram(); histogram[5] != 10 {
+		t.Errorf("BranchHistogram() = %v, want every entry under branch 5", histogram)
+	}
+}
+
+func TestExpiringSoon(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetZeroTTLNeverExpires(true); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteWithTimer([]byte("never"), []byte("v"), 0, nil)
+	c.WriteWithTimer([]byte("hour"), []byte("v"), time.Hour, nil)
+	c.WriteWithTimer([]byte("minute"), []byte("v"), time.Minute, nil)
+	c.Write(Row{K: []byte("ten seconds"), V: []byte("v")})
+	c.WriteWithTimer([]byte("five seconds"), []byte("v"), 5*time.Second, nil)
+	c.Reserve([]byte("reserved"), time.Millisecond)
+	var keys []string
+	for _, info := range c.ExpiringSoon(10) {
+		keys = append(keys, string(info.Key))
+	}
+	if got, want := strings.Join(keys, ","), "five seconds,ten seconds,minute,hour,never"; got != want {
+		t.Errorf("ExpiringSoon(10) = %s, want %s", got, want)
+	}
+	if soon := c.ExpiringSoon(1); len(soon) != 1 || string(soon[0].Key) != "five seconds" {
+		t.Errorf("ExpiringSoon(1) = %v", soon)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetTTL(60000); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	c.Write(Row{K: []byte("k"), V: []byte("first")})
+	info, ok := c.Describe([]byte("k"))
+	if !ok {
+		t.Fatal("Describe(k) = false")
+	}
+	created := info.Created
+	if created.Before(before) || created.After(time.Now()) {
+		t.Errorf("Created = %v, want the time of the write", created)
+	}
+	time.Sleep(2 * time.Millisecond)
+	written := time.Now()
+	c.Write(Row{K: []byte("k"), V: []byte("second value")})
+	c.Read([]byte("k"))
+	c.Read([]byte("k"))
+	info, ok = c.Describe([]byte("k"))
+	if !ok {
+		t.Fatal("Describe(k) = false after overwriting it")
+	}
+	if string(info.Key) != "k" {
+		t.Errorf("Key = %q, want %q", info.Key, "k")
+	}
+	if info.Size != len("second value") {
+		t.Errorf("Size = %d, want %d", info.Size, len("second value"))
+	}
+	if !info.Created.Equal(created) {
+		t.Errorf("Created = %v after overwriting, want %v", info.Created, created)
+	}
+	if !info.Accessed.After(created) {
+		t.Errorf("Accessed = %v, want after Created %v", info.Accessed, created)
+	}
+	if info.Accesses != 2 {
+		t.Errorf("Accesses = %d, want 2", info.Accesses)
+	}
+	if info.Expires.Before(written.Add(time.Minute).Truncate(time.Millisecond)) || info.Expires.After(info.Accessed.Add(time.Minute)) {
+		t.Errorf("Expires = %v, want a minute after the last write at %v", info.Expires, written)
+	}
+	if after, _ := c.Describe([]byte("k")); after.Accesses != 2 {
+		t.Error("Describe counted as an access")
+	}
+	c.Reserve([]byte("reserved"), time.Minute)
+	for _, k := range []string{"missing", "reserved"} {
+		if _, ok := c.Describe([]byte(k)); ok {
+			t.Errorf("Describe(%q) = true", k)
+		}
+	}
+}
+
+func TestBranchingFactorAndDepth(t *testing.T) {
+	c := newTestCache(t)
+	if f := c.BranchingFactor(); f != 16 {
+		t.Errorf("BranchingFactor() = %d, want 16", f)
+	}
+	if d := c.Depth(); d != 16 {
+		t.Errorf("Depth() = %d, want 16", d)
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	depth := 0
+	for n := c.find([]byte("k")).tail; n.parent != nil; n = n.parent {
+		depth++
+	}
+	if depth != c.Depth() {
+		t.Errorf("entry is %d nodes below the head, want Depth() = %d", depth, c.Depth())
+	}
+}
+
+func TestExpiryBuckets(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetZeroTTLNeverExpires(true); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(Row{K: []byte("cache ttl"), V: []byte("v")}) // 10 seconds
+	for k, ttl := range map[string]time.Duration{
+		"30s":   30 * time.Second,
+		"90s":   90 * time.Second,
+		"100s":  100 * time.Second,
+		"150s":  150 * time.Second,
+		"10m":   10 * time.Minute,
+		"never": 0,
+	} {
+		c.WriteWithTimer([]byte(k), []byte("v"), ttl, nil)
+	}
+	c.Reserve([]byte("reserved"), 30*time.Second)
+	got := c.ExpiryBuckets(time.Minute, 3)
+	if want := []int{2, 2, 1}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("ExpiryBuckets(1m, 3) = %v, want %v", got, want)
+	}
+	for _, tt := range []struct {
+		size  time.Duration
+		count int
+	}{{0, 3}, {time.Minute, 0}} {
+		if got := c.ExpiryBuckets(tt.size, tt.count); got != nil {
+			t.Errorf("ExpiryBuckets(%v, %d) = %v, want nil", tt.size, tt.count, got)
+		}
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	c := newTestCache(t)
+	if _, _, ok := c.TimeRange(); ok {
+		t.Error("TimeRange() ok for an empty cache")
+	}
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, offset := range []time.Duration{time.Hour, 0, 3 * time.Hour, 2 * time.Hour} {
+		c.Write(Row{K: key(i), V: []byte("v")})
+		c.find(key(i)).created = base.Add(offset).UnixNano()
+	}
+	c.Reserve([]byte("reserved"), time.Minute) // Created now, but not counted
+	c.Write(Row{K: key(1), V: []byte("overwritten")})
+	oldest, newest, ok := c.TimeRange()
+	if !ok || !oldest.Equal(base) || !newest.Equal(base.Add(3*time.Hour)) {
+		t.Errorf("TimeRange() = %v, %v, %v, want %v, %v", oldest, newest, ok, base, base.Add(3*time.Hour))
+	}
+	c.Delete(key(2))
+	if _, newest, _ := c.TimeRange(); !newest.Equal(base.Add(2 * time.Hour)) {
+		t.Errorf("newest = %v after deleting the newest entry, want %v", newest, base.Add(2*time.Hour))
+	}
+}
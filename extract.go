@@ -0,0 +1,82 @@
+package hashcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Extract returns a new cache holding copies of the entries whose value pred
+// returns true for, leaving the original cache unchanged.
+// The new cache has the same hash key and settings as the original, apart
+// from limits, callbacks and persistence, and the copied entries keep their
+// access times, read counts and TTLs. Reservations aren't copied.
+// pred is called under the read lock, so it must not write to the cache.
+func (c *Cache) Extract(pred func(value []byte) bool) *Cache {
+	return c.extract(pred, false)
+}
+
+// ExtractAndRemove is like Extract, but also deletes the extracted entries
+// from the original cache. pred is called under the write lock, so it must
+// not use the cache.
+func (c *Cache) ExtractAndRemove(pred func(value []byte) bool) *Cache {
+	return c.extract(pred, true)
+}
+
+func (c *Cache) extract(pred func(value []byte) bool, remove bool) *Cache {
+	if remove {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	} else {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+	}
+	dst := newCache(c.hkey0, c.hkey1)
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	dst.ttl = c.ttl
+	dst.zeroNoExpiry = c.zeroNoExpiry
+	dst.scavengeTime = c.scavengeTime
+	dst.scavengeMode = c.scavengeMode
+	dst.budget = c.budget
+	dst.extendAfter = c.extendAfter
+	dst.copyMode = c.copyMode
+	dst.codec = c.codec
+	dst.identical = c.identical
+	dst.normalizer = c.normalizer
+	dst.hashFunc = c.hashFunc
+	dst.timer.Reset(time.Duration(dst.scavengeTime) * time.Millisecond)
+	for l := c.start; l != nil; {
+		next := l.next
+		if c.tails[l.tail] == l && !l.reserved && pred(c.copyOut(*l.valuePointer)) {
+			d := dst.setLeaf(dst.path(dst.hash(l.key)), Row{K: copyBytes(l.key), V: copyBytes(*l.valuePointer)})
+			d.accessed = atomic.LoadUint64(&l.accessed)
+			d.accesses = atomic.LoadUint64(&l.accesses)
+			d.refreshed = atomic.LoadUint64(&l.refreshed)
+			d.ttl = l.ttl
+			d.version = l.version
+			d.created = l.created
+			if remove {
+				c.deleteNode(l.tail)
+			}
+		}
+		l = next
+	}
+	return dst
+}
+
+// ToMap returns a copy of every entry in the cache, keyed by its key as a
+// string, taken under the read lock. Reservations aren't included.
+// The keys and values are all copied, so the map takes about as much memory
+// again as the entries themselves, and the read lock blocks writers while it
+// is built. For a large cache, Export or an Iterator may be a better fit.
+func (c *Cache) ToMap() map[string][]byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	m := make(map[string][]byte, len(c.tails))
+	for _, l := range c.tails {
+		if !l.reserved {
+			m[string(l.key)] = copyBytes(*l.valuePointer)
+		}
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package hashcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: []byte{byte(i)}})
+	}
+	c.WriteWithTimer([]byte("timed"), []byte{100}, time.Hour, nil)
+	c.Read(key(2))
+	c.Reserve([]byte("reserved"), 0)
+	even := func(v []byte) bool { return v[0]%2 == 0 }
+
+	dst := c.Extract(even)
+	defer dst.Close()
+	if n := dst.Count(); n != 6 {
+		t.Errorf("Extract copied %d entries, want 6", n)
+	}
+	if n := c.Count(); n != 12 {
+		t.Errorf("Extract changed the original, Count() = %d", n)
+	}
+	for i := 0; i < 10; i += 2 {
+		if v, _ := dst.Read(key(i)); !bytes.Equal(v, []byte{byte(i)}) {
+			t.Errorf("extracted %q = %v", key(i), v)
+		}
+	}
+	if src, _ := c.Describe(key(2)); src.Accesses != 1 {
+		t.Errorf("original %q has %d accesses, want 1", key(2), src.Accesses)
+	}
+	src, _ := c.Describe([]byte("timed"))
+	got, _ := dst.Describe([]byte("timed"))
+	if !got.Expires.Equal(src.Expires) {
+		t.Errorf("extracted entry expires at %v, want %v", got.Expires, src.Expires)
+	}
+
+	removed := c.ExtractAndRemove(even)
+	defer removed.Close()
+	if n, m := removed.Count(), c.Count(); n != 6 || m != 6 {
+		t.Errorf("ExtractAndRemove left %d entries and moved %d, want 6 and 6", m, n)
+	}
+	if c.Has(key(0)) || !c.Has(key(1)) {
+		t.Error("ExtractAndRemove removed the wrong entries")
+	}
+}
+
+func TestToMap(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("a"), V: []byte("1")})
+	c.Write(Row{K: []byte("b"), V: []byte("2")})
+	c.Reserve([]byte("reserved"), 0)
+	m := c.ToMap()
+	if len(m) != 2 || string(m["a"]) != "1" || string(m["b"]) != "2" {
+		t.Errorf("ToMap() = %q", m)
+	}
+	m["a"][0] = 'X'
+	if v, _ := c.Read([]byte("a")); string(v) != "1" {
+		t.Error("changing a value returned by ToMap changed the cache")
+	}
+}
@@ -0,0 +1,42 @@
+package hashcache
+
+import "sync/atomic"
+
+// Handle is a reference to a value in the cache, returned by Acquire.
+// The value is shared with the cache rather than copied. The cache never
+// changes a stored value in place, as writes always store a new slice, so the
+// value stays valid until Release is called, even if the entry is deleted,
+// overwritten or expires in the meantime, and the garbage collector frees it
+// once neither the cache nor any Handle refers to it. A Handle isn't safe for
+// concurrent use.
+type Handle struct {
+	value []byte
+}
+
+// Acquire returns a Handle to the value of key, and true, or false if the
+// key isn't found or is only reserved. It counts as a read of the key.
+// The value must not be modified, and Release should be called once the
+// caller has finished with it.
+func (c *Cache) Acquire(key []byte) (*Handle, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	if l == nil || l.reserved {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.touch(l)
+	return &Handle{value: *l.valuePointer}, true
+}
+
+// Bytes returns the value held by h, or nil once h has been released.
+func (h *Handle) Bytes() []byte {
+	return h.value
+}
+
+// Release gives up h, letting go of its reference to the value. It takes no
+// lock, and calling it again has no effect.
+func (h *Handle) Release() {
+	h.value = nil
+}
@@ -0,0 +1,60 @@
+package hashcache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	c := newTestCache(t)
+	if _, ok := c.Acquire([]byte("missing")); ok {
+		t.Error("Acquire of a missing key = true")
+	}
+	c.Reserve([]byte("reserved"), 0)
+	if _, ok := c.Acquire([]byte("reserved")); ok {
+		t.Error("Acquire of a reserved key = true")
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("value")})
+	h, ok := c.Acquire([]byte("k"))
+	if !ok {
+		t.Fatal("Acquire = false")
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("other")})
+	c.Delete([]byte("k"))
+	if string(h.Bytes()) != "value" {
+		t.Errorf("Bytes() after the entry was removed = %q, want value", h.Bytes())
+	}
+	h.Release()
+	h.Release()
+	if h.Bytes() != nil {
+		t.Errorf("Bytes() after Release = %q, want nil", h.Bytes())
+	}
+}
+
+func TestAcquireEvictRace(t *testing.T) {
+	c := newTestCache(t)
+	c.SetMaxEntries(10)
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				k := key(i % 10)
+				h, ok := c.Acquire(k)
+				c.Write(Row{K: key(100 + g*1000 + i), V: []byte("evicts")})
+				if ok {
+					if string(h.Bytes()) != string(k) {
+						t.Errorf("Bytes() = %q after eviction, want %q", h.Bytes(), k)
+					}
+					h.Release()
+				}
+				c.Write(Row{K: k, V: k})
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -8,10 +8,15 @@
 package hashcache
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dchest/siphash"
@@ -22,41 +27,146 @@ const (
 	bitsPerNode = 4  // Can be 4, 8 or 16. Needs benchmarking.
 )
 
+// noExpiry is the TTL and deadline, in milliseconds, of an entry which never
+// expires.
+const noExpiry = ^uint64(0)
+
+// expiryBatch is the most expiry callbacks a scavenge queues to run once it
+// releases the lock. Expired entries with callbacks beyond these are left for
+// a scavenge which starts straight after, so the queue is never any longer.
+const expiryBatch = 256
+
 var (
 	// ErrNoRows means that the cache is empty
 	ErrNoRows = errors.New("no rows found in cache")
 	// ErrLastRow means that the current iterator row is the last row in the cache
 	ErrLastRow = errors.New("no more rows found in cache")
+	// ErrCacheFull means that the cache is at its maximum number of entries
+	// and is set to reject writes rather than evict
+	ErrCacheFull = errors.New("cache is full")
+	// ErrRateLimited means that a write was refused because the cache is
+	// receiving writes faster than its maximum write rate
+	ErrRateLimited = errors.New("cache write rate exceeded")
+	// ErrValueTooLarge means that a write was refused because its value is
+	// longer than the cache's maximum value size
+	ErrValueTooLarge = errors.New("value exceeds maximum value size")
+	// ErrZeroScavengeTime means that a scavenge time of 0 was given
+	ErrZeroScavengeTime = errors.New("scavenge time must be greater than 0 milliseconds")
+	// ErrTTLBelowScavengeTime means that a TTL was given which is shorter than
+	// the scavenge time, so entries would outlive it
+	ErrTTLBelowScavengeTime = errors.New("TTL must be greater than or equal to cache scavenge time")
 )
 
 type node struct {
 	parent *node
 	//children [1 << bitsPerNode]*node
 	children []*node
+	count    int // number of non-nil children
+	leaves   int // number of leaves stored at or beneath the node
+	// unsettled is set on a tail node whose leaf was removed with lazy
+	// pruning, but is still counted in leaves here and above.
+	unsettled bool
 }
 
 type leaf struct {
+	accessed     uint64 // first for 64 bit alignment of atomic access
+	accesses     uint64
+	refreshed    uint64 // the TTL runs from here
+	created      int64  // nanoseconds, kept when the leaf is overwritten
 	tail         *node
-	accessed     uint64
+	ttl          uint64 // milliseconds, 0 uses the cache TTL, noExpiry never expires
+	version      uint64 // set by WriteVersioned, 0 otherwise
+	reserved     bool
+	interned     bool          // value is shared through the slab
+	expiry       chan struct{} // closed when the leaf is removed or overwritten
+	onExpire     func(value []byte)
 	key          []byte
 	valuePointer *[]byte
 	prev         *leaf
 	next         *leaf
+	newer        *leaf // neighbours in the LRU list
+	older        *leaf
 }
 
 // Cache is a hash tree of keys which have been hashed using SipHash.
 // It stores pointers to the values associated with the keys.
 // It supports customisable key Time To Live and scavenge time.
+// It is safe for concurrent use. Every write walks and extends the trie
+// while holding the write lock, so concurrent writes of keys whose hashes
+// share a prefix never lose each other's nodes.
 type Cache struct {
 	hkey0        uint64
 	hkey1        uint64
+	salt         uint64
+	saltRotation uint64 // milliseconds, 0 disables rotation
+	saltRotated  uint64 // milliseconds
+	hits         uint64 // hits, misses and evictions are accessed atomically
+	misses       uint64
+	evictions    uint64
+	lastScavenge int64    // nanoseconds, accessed atomically
+	slowCallback int64    // nanoseconds, accessed atomically
+	fired        []func() // expiry callbacks queued by the scavenge in progress
+	examined     int      // leaves the last scavenge checked for expiry
+	readLatency  latencyRecorder
+	writeLatency latencyRecorder
+	trackLatency int32 // accessed atomically
 	head         *node
 	tails        map[*node]*leaf
 	start        *leaf
-	ttl          uint64 // milliseconds
+	newest       *leaf      // the most recently used end of the LRU list
+	oldest       *leaf      // the least recently used end, evicted first
+	lruMu        sync.Mutex // guards the LRU list, which touch moves under the read lock
+	ttl          uint64     // milliseconds, 0 only if zeroNoExpiry
+	zeroNoExpiry bool
 	scavengeTime uint64 // milliseconds
 	timer        *time.Timer
-	mu           *sync.RWMutex
+	mu           *lock
+	empty        *sync.Cond // signalled when the last entry is removed
+	onShed       func(freed int64)
+	maxEntries   int // 0 is unlimited
+	maxValueSize int // bytes, 0 is unlimited
+	rejectOnFull bool
+	copyMode     CopyMode
+	codec        Codec
+	identical    IdenticalWriteMode
+	extendAfter  uint64 // reads needed before a read extends the TTL
+	watchers     map[string][]chan []byte
+	done         chan struct{} // closed by Close to stop the scavenger
+	closeOnce    sync.Once
+	snapshotPath string // written by Close, if set
+	repairFn     func(key []byte) ([]byte, error)
+	repairWindow uint64        // milliseconds
+	repairSlots  chan struct{} // one per running repair
+	repairMu     sync.Mutex    // guards repairing, as repair runs under the read lock
+	repairing    map[string]bool
+	dependents   map[string]map[string]bool // keys derived from each key
+	dependsOn    map[string][]string        // keys each derived key came from
+	scavengeMode ScavengeMode
+	budget       int        // leaves checked per scavenge in LowLatency mode
+	scavengeNext *leaf      // where the next LowLatency scavenge starts
+	rateMu       sync.Mutex // guards the write rate limiter, which blocks without the cache lock
+	writeRate    int        // writes per second, 0 is unlimited
+	blockOnRate  bool
+	tokens       float64
+	tokensAt     time.Time
+	normalizer   func([]byte) []byte
+	loaders      []prefixLoader // longest prefix first
+	alertFn      func(ratio float64)
+	alertBelow   float64
+	alertHits    uint64 // hits and misses at the last hit ratio check
+	alertMisses  uint64
+	hashFunc     func([]byte) uint64     // nil uses SipHash
+	bloom        *bloom                  // nil unless enabled
+	lazyPrune    bool                    // removals leave their nodes for compact
+	unpruned     []*node                 // tail nodes awaiting pruning, some perhaps reused since
+	settled      int                     // how many of unpruned, from the start, are settled
+	nodes        int                     // nodes in the trie below the head
+	emptyNodes   int                     // nodes below the head with no leaf beneath them
+	compactAbove float64                 // fragmentation which triggers pruning, 0 prunes every scavenge
+	slab         map[uint64][]*slabValue // nil unless values are deduplicated
+	numeric      *numericStats           // nil unless numeric values are tracked
+	loadMu       sync.Mutex              // guards loading, as loaders run without the cache lock
+	loading      map[string]*loadCall
 }
 
 // Iterator is used to iterate over all values in the Cache
@@ -87,19 +197,30 @@ func NewCache(hashKey string) *Cache {
 	if hKeyLen > 16 {
 		hKeyBytes = hKeyBytes[len(hKeyBytes)-16:] // Truncate hash key value
 	}
+	return newCache(binary.LittleEndian.Uint64(hKeyBytes[:8]), binary.LittleEndian.Uint64(hKeyBytes[8:]))
+}
+
+// newCache returns a Cache with the given hash key and default settings,
+// with its scavenger running.
+func newCache(hkey0, hkey1 uint64) *Cache {
 	c := &Cache{
-		hkey0: binary.LittleEndian.Uint64(hKeyBytes[:8]),
-		hkey1: binary.LittleEndian.Uint64(hKeyBytes[8:]),
-		head: &node{
-			parent: nil,
-			//children: [1 << bitsPerNode]*node{},
-			children: make([]*node, 1<<bitsPerNode),
-		},
+		hkey0:        hkey0,
+		hkey1:        hkey1,
+		head:         newNode(nil),
 		tails:        map[*node]*leaf{},
+		watchers:     map[string][]chan []byte{},
+		done:         make(chan struct{}),
+		repairing:    map[string]bool{},
+		loading:      map[string]*loadCall{},
+		dependents:   map[string]map[string]bool{},
+		dependsOn:    map[string][]string{},
+		codec:        JSONCodec,
 		ttl:          10000,
 		scavengeTime: 1000,
-		mu:           &sync.RWMutex{},
+		mu:           &lock{},
 	}
+	c.empty = sync.NewCond(&c.mu.RWMutex) // Waits in WaitEmpty aren't lock contention
+	c.lastScavenge = time.Now().UnixNano()
 	c.timer = time.NewTimer(time.Duration(c.scavengeTime) * time.Millisecond)
 	go c.scavenge()
 	return c
@@ -116,7 +237,8 @@ func (i *Iterator) Value() (Row, error) {
 	i.cache.mu.RLock()
 	defer i.cache.mu.RUnlock()
 	if i.current != nil {
-		return Row{K: i.current.key, V: *i.current.valuePointer}, nil
+		c := i.cache
+		return Row{K: c.copyOut(i.current.key), V: c.copyOut(*i.current.valuePointer)}, nil
 	}
 	return Row{}, ErrNoRows
 }
@@ -134,81 +256,356 @@ func (i *Iterator) Next() error {
 }
 
 // Write will add the key and value to the cache.
-// It will overwrite the key if it already exists.
+// It will overwrite the key if it already exists. When several goroutines
+// write the same key concurrently, exactly one value survives, from
+// whichever write acquired the lock last.
+// If the cache is full and set to reject writes, the write is dropped;
+// use WriteErr to find out when that happens.
 func (c *Cache) Write(r Row) {
-	hash := c.hash(r.K)
-	currentNode := c.head
-	for i := 0; i < hashLen/bitsPerNode; i++ {
-		currentByte := hash & (hashLen/bitsPerNode - 1)
-		if currentNode.children[currentByte] == nil {
-			c.mu.Lock()
-			currentNode.children[currentByte] = &node{
-				parent: currentNode,
-				//children: [1 << bitsPerNode]*node{},
-				children: make([]*node, 1<<bitsPerNode),
-			}
-			c.mu.Unlock()
-		}
-		currentNode = currentNode.children[currentByte]
-		hash = hash >> bitsPerNode
+	c.WriteErr(r)
+}
+
+// WriteErr will add the key and value to the cache, like Write.
+// It will return ErrCacheFull if the key is new, the cache is at its
+// maximum number of entries, and it is set to reject writes when full,
+// ErrRateLimited if the write exceeds the maximum write rate and the
+// cache is set not to wait, or ErrValueTooLarge if the value is longer
+// than the maximum value size.
+func (c *Cache) WriteErr(r Row) error {
+	if atomic.LoadInt32(&c.trackLatency) != 0 {
+		defer c.writeLatency.since(time.Now())
 	}
-	c.mu.Lock()
-	c.tails[currentNode] = &leaf{
-		tail:         currentNode,
-		accessed:     uint64(time.Now().UnixNano()),
-		key:          r.K,
-		valuePointer: &r.V,
-	}
-	prev := c.getRandomLeaf()
-	if prev != nil {
-		c.tails[currentNode].prev = prev
-		c.tails[currentNode].next = prev.next
-		prev.next = c.tails[currentNode]
+	if err := c.takeWriteToken(); err != nil {
+		return err
 	}
-	if len(c.tails) == 0 {
-		c.start = c.tails[currentNode]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writeIdentical(r) {
+		return nil
 	}
-	c.mu.Unlock()
+	_, err := c.insert(r)
+	return err
 }
 
 // Read will try to read the value of a given key from the cache.
 // It will return the data as []byte and true if the key is found,
 // otherwise it will return false if the key isn't found.
+// A value written as nil or empty is returned as a non-nil empty slice and
+// true, while a missing key always returns nil and false.
+// A missing key with a loader registered by RegisterLoader is loaded first,
+// but a reserved key isn't, as its value is already being computed.
 func (c *Cache) Read(key []byte) ([]byte, bool) {
+	if atomic.LoadInt32(&c.trackLatency) != 0 {
+		defer c.readLatency.since(time.Now())
+	}
+	v, ok, loader := c.read(key)
+	if ok || loader == nil {
+		return v, ok
+	}
+	return c.readThrough(key, loader)
+}
+
+// read is Read without the read through, returning the loader registered for
+// key if it is missing and not reserved.
+func (c *Cache) read(key []byte) ([]byte, bool, func(key []byte) ([]byte, error)) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	hash := c.hash(key)
-	currentNode := c.head
-	for i := 0; i < hashLen/bitsPerNode; i++ {
-		currentByte := hash & (hashLen/bitsPerNode - 1)
-		if currentNode.children[currentByte] == nil {
-			return nil, false
-		}
-		currentNode = currentNode.children[currentByte]
-		hash = hash >> bitsPerNode
+	l := c.find(key)
+	switch {
+	case l == nil:
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false, c.loaderFor(key)
+	case l.reserved:
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false, nil // Left to whoever holds the reservation
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.repair(l)
+	c.touch(l)
+	return c.copyOut(*l.valuePointer), true, nil
+}
+
+// Reserve will insert a placeholder for the key, signalling to other callers
+// that its value is being computed and that they should back off.
+// The placeholder expires after ttl unless it is overwritten by a Write first.
+// A ttl of less than 1 millisecond uses the cache TTL, or never expires if
+// SetZeroTTLNeverExpires is on.
+// It will return true if the caller won the reservation, or false if the key
+// is already present, either with a value or as another caller's reservation.
+func (c *Cache) Reserve(key []byte, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.find(key) != nil || c.admit(key) != nil {
+		return false
+	}
+	l := c.setLeaf(c.path(c.hash(key)), Row{K: key})
+	l.ttl = c.leafTTL(ttl)
+	l.reserved = true
+	return true
+}
+
+// ReadOrReserved will try to read the value of a given key from the cache,
+// distinguishing a real value from a placeholder inserted by Reserve.
+// It will return the value, false and true if the key holds a value,
+// nil, true and true if the key is reserved, or nil, false and false
+// if the key isn't found.
+func (c *Cache) ReadOrReserved(key []byte) (value []byte, reserved bool, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	switch {
+	case l == nil:
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false, false
+	case l.reserved:
+		atomic.AddUint64(&c.misses, 1)
+		return nil, true, true
+	}
+	atomic.AddUint64(&c.hits, 1)
+	c.touch(l)
+	return c.copyOut(*l.valuePointer), false, true
+}
+
+// ReadAndRefresh will read the value of a given key from the cache and restart
+// its TTL in one operation, whatever the extend threshold.
+// It will return the data as []byte, whether the entry had already expired
+// but not yet been removed by the scavenger (so the value served is stale),
+// and true if the key is found, otherwise it will return false.
+func (c *Cache) ReadAndRefresh(key []byte) (value []byte, wasStale bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.find(key)
+	if l == nil || l.reserved {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	wasStale = c.expired(l, uint64(time.Now().UnixNano()/1e6))
+	l.accesses++
+	c.refresh(l)
+	return c.copyOut(*l.valuePointer), wasStale, true
+}
+
+// Has reports whether key holds a value, without reading it.
+// Like ValueLen, a reserved key isn't counted, and it doesn't count as an
+// access of the key.
+func (c *Cache) Has(key []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	return l != nil && !l.reserved
+}
+
+// HasMany reports whether each of keys holds a value, like Has, taking the
+// read lock once for the whole batch. The result is in the same order as keys.
+func (c *Cache) HasMany(keys [][]byte) []bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	has := make([]bool, len(keys))
+	for i, key := range keys {
+		l := c.find(key)
+		has[i] = l != nil && !l.reserved
+	}
+	return has
+}
+
+// ValueLen returns the length of the value stored for key, and true,
+// or false if the key isn't found or is only reserved.
+// It doesn't count as an access of the key.
+func (c *Cache) ValueLen(key []byte) (int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	if l == nil || l.reserved {
+		return 0, false
 	}
-	c.tails[currentNode].accessed = uint64(time.Now().UnixNano())
-	return *c.tails[currentNode].valuePointer, true
+	return len(*l.valuePointer), true
+}
+
+// WouldCollide reports whether key hashes to the same value as a different
+// key already in the cache. Writing such a key replaces the other key's entry,
+// so this can be used to check a key set for collisions, for instance before
+// switching to a weaker hash.
+func (c *Cache) WouldCollide(key []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	l := c.find(key)
+	return l != nil && !bytes.Equal(l.key, c.normalize(key))
+}
+
+// CollisionProbability estimates the probability that at least two of the
+// keys in the cache share a hash, from the birthday bound for the current
+// number of entries and the hash width. WouldCollide checks a particular key.
+func (c *Cache) CollisionProbability() float64 {
+	return collisionProbability(c.Count(), hashLen)
+}
+
+// collisionProbability approximates the chance of a collision among n
+// uniformly distributed hashes of the given width in bits.
+func collisionProbability(n, bits int) float64 {
+	pairs := float64(n) * float64(n-1) / 2
+	return -math.Expm1(-pairs / math.Exp2(float64(bits)))
 }
 
 // Delete will remove an entry from the cache.
 func (c *Cache) Delete(key []byte) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	hash := c.hash(key)
-	currentNode := c.head
-	for i := 0; i < hashLen/bitsPerNode; i++ {
-		currentByte := hash & (hashLen/bitsPerNode - 1)
-		if currentNode.children[currentByte] == nil {
-			return false
-		}
-		currentNode = currentNode.children[currentByte]
-		hash = hash >> bitsPerNode
+	l := c.find(key)
+	if l == nil {
+		return false
+	}
+	c.deleteNode(l.tail)
+	return true
+}
+
+// CompareAndDelete will remove the entry for key only if its value is equal
+// to expected, returning whether it was removed, like sync.Map's method of
+// the same name. A missing or reserved key is never removed.
+func (c *Cache) CompareAndDelete(key, expected []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.find(key)
+	if l == nil || l.reserved || !bytes.Equal(*l.valuePointer, expected) {
+		return false
+	}
+	c.deleteNode(l.tail)
+	return true
+}
+
+// SwapKeys exchanges the values of keys a and b, leaving each entry's TTL,
+// access time and read count where they were. It returns false, changing
+// nothing, if either key is missing or only reserved. Like a Write of each key,
+// it notifies watchers and removes any entries derived from either key.
+func (c *Cache) SwapKeys(a, b []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	la, lb := c.find(a), c.find(b)
+	if la == nil || lb == nil || la.reserved || lb.reserved {
+		return false
+	}
+	if la == lb {
+		return true
 	}
-	c.deleteNode(currentNode)
+	la.valuePointer, lb.valuePointer = lb.valuePointer, la.valuePointer
+	la.interned, lb.interned = lb.interned, la.interned
+	c.notify(la)
+	c.notify(lb)
+	c.changed(la.key)
+	c.changed(lb.key)
 	return true
 }
 
+// Drain passes the value of each entry in the cache to fn and removes the
+// entry, stopping when fn returns false, in which case that entry is kept.
+// It returns the number of entries removed. Reservations are left alone.
+// It holds the write lock throughout, so fn must not use the cache.
+func (c *Cache) Drain(fn func(value []byte) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	drained := 0
+	for l := c.start; l != nil; {
+		next := l.next
+		if c.tails[l.tail] == l && !l.reserved {
+			if !fn(c.copyOut(*l.valuePointer)) {
+				break
+			}
+			c.deleteNode(l.tail)
+			drained++
+		}
+		l = next
+	}
+	return drained
+}
+
+// Reinitialize returns the cache to the state it was in when it was created,
+// removing every entry and dependency, and zeroing the hit, miss, eviction,
+// latency and lock wait statistics, while keeping its hash key and settings.
+// The scavenge timer restarts, and the write rate limiter's bucket is refilled.
+// Watchers stay registered, and Handles already acquired stay readable.
+func (c *Cache) Reinitialize() {
+	c.mu.Lock()
+	for n := range c.tails {
+		c.deleteNode(n)
+	}
+	c.head = newNode(nil)
+	c.nodes, c.emptyNodes = 0, 0
+	c.unpruned, c.settled = nil, 0
+	c.start = nil
+	c.newest, c.oldest = nil, nil
+	c.scavengeNext = nil
+	c.dependents = map[string]map[string]bool{}
+	c.dependsOn = map[string][]string{}
+	if c.bloom != nil {
+		c.bloom.reset()
+	}
+	atomic.StoreUint64(&c.hits, 0)
+	atomic.StoreUint64(&c.misses, 0)
+	atomic.StoreUint64(&c.evictions, 0)
+	c.alertHits, c.alertMisses = 0, 0
+	for i := range c.readLatency {
+		atomic.StoreUint64(&c.readLatency[i], 0)
+		atomic.StoreUint64(&c.writeLatency[i], 0)
+	}
+	c.mu.reset()
+	c.timer.Reset(time.Duration(c.scavengeTime) * time.Millisecond)
+	atomic.StoreInt64(&c.lastScavenge, time.Now().UnixNano())
+	c.mu.Unlock()
+	c.rateMu.Lock()
+	c.tokens = float64(c.writeRate)
+	c.tokensAt = time.Now()
+	c.rateMu.Unlock()
+}
+
+// CountFunc returns the number of entries whose value pred returns true for,
+// without removing any. Reservations aren't counted.
+// It holds the read lock while pred is called for every entry, which blocks
+// writers for the whole scan on a large cache, so pred should be quick and
+// must not write to the cache.
+func (c *Cache) CountFunc(pred func(value []byte) bool) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	count := 0
+	for _, l := range c.tails {
+		if !l.reserved && pred(c.copyOut(*l.valuePointer)) {
+			count++
+		}
+	}
+	return count
+}
+
+// TouchMany will extend the TTL of each key found in keys, as if it had just
+// been written, under a single write lock. Reservations are left alone.
+// It returns the number of keys found.
+func (c *Cache) TouchMany(keys [][]byte) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	touched := 0
+	for _, key := range keys {
+		if l := c.find(key); l != nil && !l.reserved {
+			c.refresh(l)
+			touched++
+		}
+	}
+	return touched
+}
+
+// ExpiryChannel returns a channel which is closed when the entry for key is
+// deleted, overwritten or expires, or false if the key isn't found or is only
+// reserved.
+func (c *Cache) ExpiryChannel(key []byte) (<-chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.find(key)
+	if l == nil || l.reserved {
+		return nil, false
+	}
+	if l.expiry == nil {
+		l.expiry = make(chan struct{})
+	}
+	return l.expiry, true
+}
+
 // Count returns the number of keys in the cache.
 func (c *Cache) Count() int {
 	c.mu.RLock()
@@ -216,15 +613,60 @@ func (c *Cache) Count() int {
 	return len(c.tails)
 }
 
+// WaitEmpty blocks until the cache holds no entries, either because they have
+// expired or been deleted, or until ctx is done, in which case it returns
+// the context's error.
+func (c *Cache) WaitEmpty(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			c.empty.Broadcast()
+			c.mu.Unlock()
+		case <-done:
+		}
+	}()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tails) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.empty.Wait()
+	}
+	return nil
+}
+
+// Close stops the scavenger, after which entries no longer expire.
+// If the cache was created by NewCachePersistent, it then writes a snapshot
+// of the cache to its file. Only the first call has any effect.
+func (c *Cache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if c.snapshotPath != "" {
+			err = c.save(c.snapshotPath)
+		}
+	})
+	return err
+}
+
+// TimeSinceScavenge returns how long ago the scavenger last finished a pass,
+// or how long ago the cache was created if it hasn't yet. A value growing well
+// beyond the scavenge time means the scavenger is stalled or stopped.
+func (c *Cache) TimeSinceScavenge() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastScavenge)))
+}
+
 // SetScavengeTime sets the frequency (in milliseconds) that the cache will check
 // for entries that are older than their TTL.
-// It must be greater than 0 milliseconds, and less than or equal to the cache TTL.
+// It must be greater than 0 milliseconds, and less than or equal to the cache
+// TTL unless the TTL is 0.
 func (c *Cache) SetScavengeTime(st uint64) error {
-	if st == 0 {
-		return fmt.Errorf("scavenge time must be greater than 0 milliseconds")
-	}
-	if st > c.ttl {
-		return fmt.Errorf("scavenge time must be less than or equal to cache TTL")
+	if err := validateTimings(c.ttl, st, true); err != nil {
+		return err
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -233,11 +675,49 @@ func (c *Cache) SetScavengeTime(st uint64) error {
 	return nil
 }
 
+// SetMaxEntries sets the maximum number of entries the cache will hold.
+// A value of 0 removes the limit. Lowering the limit doesn't remove any
+// entries until the next write of a new key.
+func (c *Cache) SetMaxEntries(n int) error {
+	if n < 0 {
+		return fmt.Errorf("maximum entries must not be negative")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxEntries = n
+	return nil
+}
+
+// SetRejectOnFull sets what happens when a new key is written to a cache
+// which is at its maximum number of entries.
+// By default the least recently used entry is evicted to make room.
+// If reject is true, nothing is evicted and the write fails with ErrCacheFull
+// instead, leaving admission control to the caller. Overwriting an existing
+// key never needs room, so it is never rejected.
+func (c *Cache) SetRejectOnFull(reject bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rejectOnFull = reject
+}
+
+// SetExtendThreshold sets how many times an entry must be read before a read
+// extends its TTL. By default every read extends the TTL, so entries expire
+// TTL milliseconds after their last access. With a threshold, entries which
+// are only read a few times expire TTL milliseconds after they were written,
+// and only popular entries are kept alive by reading them.
+// A threshold of 0 or 1 extends the TTL on every read.
+func (c *Cache) SetExtendThreshold(reads uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extendAfter = reads
+}
+
 // SetTTL Sets the Time-To-Live value for cache entries.
-// It must be greater than or equal to the scavenge time for the cache.
+// It must be greater than or equal to the scavenge time for the cache, or 0
+// if SetZeroTTLNeverExpires is on, in which case entries never expire.
 func (c *Cache) SetTTL(ttl uint64) error {
-	if ttl < c.scavengeTime {
-		return fmt.Errorf("TTL must be greater than or equal to cache scavenge time")
+	if err := validateTimings(ttl, c.scavengeTime, c.zeroNoExpiry); err != nil {
+		return err
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -245,66 +725,537 @@ func (c *Cache) SetTTL(ttl uint64) error {
 	return nil
 }
 
+// ValidateTimings checks a TTL and scavenge time, both in milliseconds, without
+// changing any cache, returning the error SetTTL and SetScavengeTime would
+// return for the pair: ErrZeroScavengeTime or ErrTTLBelowScavengeTime.
+// A TTL of 0 is only valid with SetZeroTTLNeverExpires, so it is rejected here.
+func ValidateTimings(ttl, scavenge uint64) error {
+	return validateTimings(ttl, scavenge, false)
+}
+
+// validateTimings is ValidateTimings, also accepting a TTL of 0 if zeroTTL.
+func validateTimings(ttl, scavenge uint64, zeroTTL bool) error {
+	if scavenge == 0 {
+		return ErrZeroScavengeTime
+	}
+	if ttl < scavenge && !(ttl == 0 && zeroTTL) {
+		return ErrTTLBelowScavengeTime
+	}
+	return nil
+}
+
+// SetZeroTTLNeverExpires sets whether a TTL of 0 means an entry never expires.
+// When it is on, SetTTL accepts 0, making every entry which uses the cache TTL
+// permanent, and a per-entry TTL of less than 1 millisecond, as passed to
+// Reserve or WriteWithTimer, makes just that entry permanent. Permanent
+// entries are skipped by the scavenger, but can still be deleted, and evicted
+// when the cache is full. It can't be turned off while the cache TTL is 0,
+// and turning it off doesn't affect entries already written.
+func (c *Cache) SetZeroTTLNeverExpires(enabled bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !enabled && c.ttl == 0 {
+		return fmt.Errorf("cache TTL must be set above 0 first")
+	}
+	c.zeroNoExpiry = enabled
+	return nil
+}
+
+// leafTTL converts a per-entry TTL to the milliseconds stored in a leaf.
+// The caller must hold the write lock.
+func (c *Cache) leafTTL(ttl time.Duration) uint64 {
+	ms := uint64(ttl / time.Millisecond)
+	if ms == 0 && c.zeroNoExpiry {
+		return noExpiry
+	}
+	return ms
+}
+
+// SetSaltRotation enables mixing a random salt into the hash key, replacing
+// the salt with a new one every period milliseconds. A period of 0 disables
+// salting and restores the plain hash key.
+//
+// This is intended for caches whose keys are attacker controlled. Without a
+// salt, an attacker who learns the hash key can precompute keys sharing a
+// trie path, or probe the timing of Read and Write to infer which keys are
+// present. With a salt, any such knowledge only holds until the next rotation.
+// It does not make a single lookup constant time, and it does not protect
+// the hash key itself.
+//
+// Every stored key is rehashed under the write lock on each rotation, so the
+// trie is always consistent with the current salt. Rotation is checked on each
+// scavenge, so the effective period is rounded up to the scavenge time.
+func (c *Cache) SetSaltRotation(period uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saltRotation = period
+	if period == 0 {
+		if c.salt != 0 {
+			c.salt = 0
+			c.rehash()
+		}
+		return nil
+	}
+	return c.rotateSalt()
+}
+
+// SetKeyNormalizer sets a function which every key is passed through before
+// it is hashed or stored, such as bytes.ToLower to make keys case insensitive.
+// All operations use the same normalizer, so keys which normalize to the same
+// bytes always resolve to the same entry, and stored keys are returned in their
+// normalized form. The normalizer must be idempotent, must not modify its
+// argument, and must be safe to call concurrently.
+// Existing keys are normalized again and rehashed, as are the keys of
+// watchers and dependencies. Of any keys which now normalize to the same
+// bytes, an arbitrary one is kept and the others are removed as if they had
+// been deleted. Passing nil removes the normalizer.
+func (c *Cache) SetKeyNormalizer(fn func([]byte) []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.normalizer = fn
+	if fn == nil {
+		return
+	}
+	for _, l := range c.tails {
+		l.key = fn(l.key)
+	}
+	c.rekeyWatchers(fn)
+	c.rekeyDeps(fn)
+	c.rehash()
+}
+
+// normalize returns key passed through the key normalizer, if there is one.
+func (c *Cache) normalize(key []byte) []byte {
+	if c.normalizer == nil {
+		return key
+	}
+	return c.normalizer(key)
+}
+
+// SetHashFunc replaces SipHash with fn for hashing keys, rehashing every
+// stored key with it before swapping in the new trie. Passing nil restores
+// SipHash with the cache's hash key.
+// It returns an error, leaving the cache unchanged, if fn hashes two stored
+// keys to the same value, as one of them would be lost.
+// The salt is not applied to fn, so salt rotation has no effect while it is
+// set. fn must be safe to call concurrently.
+func (c *Cache) SetHashFunc(fn func([]byte) uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fn != nil {
+		seen := make(map[uint64]bool, len(c.tails))
+		for _, l := range c.tails {
+			h := fn(l.key)
+			if seen[h] {
+				return fmt.Errorf("hash function collides for stored key %q", l.key)
+			}
+			seen[h] = true
+		}
+	}
+	c.hashFunc = fn
+	c.rehash()
+	return nil
+}
+
 func (c *Cache) hash(data []byte) uint64 {
-	return siphash.Hash(c.hkey0, c.hkey1, data)
+	if c.hashFunc != nil {
+		return c.hashFunc(c.normalize(data))
+	}
+	return siphash.Hash(c.hkey0^c.salt, c.hkey1, c.normalize(data))
 }
 
-func checkParent(n *node) bool {
-	count := 0
-	for _, c := range n.parent.children {
-		if c != nil {
-			count++
+// rotateSalt replaces the salt with a new random value and rehashes the trie.
+// The caller must hold the write lock.
+func (c *Cache) rotateSalt() error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("unable to generate salt: %v", err)
+	}
+	c.salt = binary.LittleEndian.Uint64(b)
+	c.saltRotated = uint64(time.Now().UnixNano() / 1e6)
+	c.rehash()
+	return nil
+}
+
+// rehash rebuilds the trie by hashing every stored key again, keeping the leaves
+// and their order. If keys now collide, only one of them is kept, and which one
+// is down to map iteration order. The others are removed as if they had been
+// deleted. The caller must hold the write lock.
+func (c *Cache) rehash() {
+	tails := c.tails
+	c.head = newNode(nil)
+	c.nodes, c.emptyNodes = 0, 0
+	c.unpruned, c.settled = nil, 0
+	if c.bloom != nil {
+		c.bloom.reset()
+	}
+	c.tails = make(map[*node]*leaf, len(tails))
+	var dropped []*leaf
+	for _, l := range tails {
+		n := c.path(c.hash(l.key))
+		if c.tails[n] != nil {
+			dropped = append(dropped, l)
+			continue
 		}
-		if count > 1 {
-			return false
+		l.tail = n
+		c.tails[n] = l
+		c.leafAdded(n)
+	}
+	for _, l := range dropped {
+		c.removeLeaf(l)
+	}
+}
+
+// find returns the leaf for key, or nil if the key isn't in the cache.
+// The caller must hold the read or write lock.
+func (c *Cache) find(key []byte) *leaf {
+	hash := c.hash(key)
+	if c.bloom != nil && !c.bloom.has(hash) {
+		return nil
+	}
+	currentNode := c.head
+	for i := 0; i < hashLen/bitsPerNode; i++ {
+		currentNode = currentNode.child(hash & (1<<bitsPerNode - 1))
+		if currentNode == nil {
+			return nil
 		}
+		hash = hash >> bitsPerNode
 	}
-	return true
+	return c.tails[currentNode]
 }
 
-func (c *Cache) deleteNode(n *node) {
-	c.tails[n].valuePointer = nil // TODO: check if this is necessary
-	if c.tails[n] == c.start {
-		switch {
-		case c.tails[n].next != nil:
-			c.start = c.tails[n].next
-		case c.tails[n].next == nil:
-			c.start = nil
+// path returns the tail node for hash, creating any missing nodes on the way,
+// and adds hash to the bloom filter. The caller must hold the write lock.
+func (c *Cache) path(hash uint64) *node {
+	if c.bloom != nil {
+		c.bloom.add(hash)
+	}
+	currentNode := c.head
+	for i := 0; i < hashLen/bitsPerNode; i++ {
+		currentByte := hash & (1<<bitsPerNode - 1)
+		next := currentNode.child(currentByte)
+		if next == nil {
+			next = newNode(currentNode)
+			currentNode.setChild(currentByte, next)
+			c.nodes++
+			c.emptyNodes++ // Until a leaf is stored beneath it
 		}
+		currentNode = next
+		hash = hash >> bitsPerNode
 	}
-	switch {
-	case c.tails[n].prev != nil && c.tails[n].next != nil:
-		c.tails[n].prev.next = c.tails[n].next
-	case c.tails[n].prev == nil:
-		c.tails[n].next.prev = nil
-	case c.tails[n].next == nil:
-		c.tails[n].prev.next = nil
-	}
-	for checkParent(n) {
-		n = n.parent
-		//n.children = [1 << bitsPerNode]*node{}
-		n.children = nil
+	return currentNode
+}
+
+// insert stores r, first making room for it if its key is new and the cache
+// is full, and notifies any watchers of the key. It returns ErrValueTooLarge
+// or ErrCacheFull if r can't be stored.
+// The caller must hold the write lock.
+func (c *Cache) insert(r Row) (*leaf, error) {
+	if !c.fits(len(r.V)) {
+		return nil, ErrValueTooLarge
+	}
+	if err := c.admit(r.K); err != nil {
+		return nil, err
+	}
+	l := c.setLeaf(c.path(c.hash(r.K)), r)
+	c.notify(l)
+	return l, nil
+}
+
+// admit makes room for key if it is new and the cache is full, or returns
+// ErrCacheFull if the cache rejects writes when full.
+// The caller must hold the write lock.
+func (c *Cache) admit(key []byte) error {
+	if c.maxEntries > 0 && len(c.tails) >= c.maxEntries && c.find(key) == nil {
+		if c.rejectOnFull {
+			return ErrCacheFull
+		}
+		for len(c.tails) >= c.maxEntries {
+			c.evictOldest()
+		}
+	}
+	return nil
+}
+
+// evictOldest deletes the least recently used leaf.
+// The caller must hold the write lock.
+func (c *Cache) evictOldest() {
+	if c.oldest != nil {
+		c.evict(c.oldest.tail)
+	}
+}
+
+// setLeaf stores r at the tail node n, overwriting any existing leaf in place
+// or linking a new leaf in at the start of the leaf list.
+// The caller must hold the write lock.
+func (c *Cache) setLeaf(n *node, r Row) *leaf {
+	l := c.tails[n]
+	if l == nil {
+		l = &leaf{tail: n, next: c.start, created: time.Now().UnixNano()}
+		if c.start != nil {
+			c.start.prev = l
+		}
+		c.start = l
+		c.tails[n] = l
+		c.reuse(n)
+	}
+	closeExpiry(l)
+	c.refresh(l)
+	l.ttl = 0
+	l.version = 0
+	l.reserved = false
+	l.onExpire = nil
+	l.key = c.copyIn(c.normalize(r.K))
+	if l.interned {
+		c.unintern(*l.valuePointer)
+	}
+	v := c.copyIn(r.V)
+	if v == nil {
+		v = []byte{} // Keep a stored empty value distinct from a miss
+	}
+	if l.interned = c.slab != nil; l.interned {
+		v = c.intern(v)
+	}
+	c.trackNumeric(l.valuePointer, &v)
+	l.valuePointer = &v
+	c.changed(l.key)
+	return l
+}
+
+// expire evicts the leaf at the tail node n because it has expired, queueing
+// its expiry callback, if any, to run once the scavenge releases the lock.
+// If expiryBatch callbacks are already queued, a leaf with a callback is left
+// in place for the next scavenge.
+func (c *Cache) expire(n *node) {
+	if l := c.tails[n]; l != nil && l.onExpire != nil {
+		if len(c.fired) >= expiryBatch {
+			return
+		}
+		f, v := l.onExpire, copyBytes(*l.valuePointer)
+		c.fired = append(c.fired, func() { f(v) })
+	}
+	c.evict(n)
+}
+
+// evict deletes the leaf at the tail node n, counting it as an eviction.
+func (c *Cache) evict(n *node) {
+	atomic.AddUint64(&c.evictions, 1)
+	c.deleteNode(n)
+}
+
+// deleteNode removes the leaf at the tail node n and prunes any nodes
+// left without children, stopping at the head. With lazy pruning it leaves
+// the path alone, for settle and compact to deal with later.
+func (c *Cache) deleteNode(n *node) {
+	l := c.tails[n]
+	if l == nil {
+		return // Already removed along with an entry it depended on
 	}
 	delete(c.tails, n)
+	if c.bloom != nil {
+		c.bloom.stale++
+	}
+	if len(c.tails) == 0 {
+		c.empty.Broadcast()
+	}
+	if c.lazyPrune {
+		n.unsettled = true
+		c.unpruned = append(c.unpruned, n)
+	} else {
+		c.leafRemoved(n)
+		c.prune(n)
+	}
+	c.removeLeaf(l)
+}
+
+// removeLeaf lets go of everything l holds apart from its place in the trie,
+// and removes any entries derived from it.
+// The caller must hold the write lock.
+func (c *Cache) removeLeaf(l *leaf) {
+	c.trackNumeric(l.valuePointer, nil)
+	if l.interned {
+		c.unintern(*l.valuePointer)
+		l.interned = false
+	}
+	c.unlink(l)
+	c.unlinkLRU(l)
+	closeExpiry(l)
+	c.changed(l.key)
+}
+
+// prune removes the tail node n, which no longer holds a leaf, and any nodes
+// above it left without children, stopping at the head.
+// The caller must hold the write lock.
+func (c *Cache) prune(n *node) {
+	for n.parent != nil && n.count == 0 && c.tails[n] == nil {
+		if !n.parent.removeChild(n) {
+			return // Already pruned
+		}
+		c.nodes--
+		c.emptyNodes--
+		n = n.parent
+	}
+}
+
+// leafAdded counts a leaf stored at the tail node n in n and every node above
+// it, none of which are empty any longer.
+// The caller must hold the write lock.
+func (c *Cache) leafAdded(n *node) {
+	for ; n.parent != nil; n = n.parent {
+		if n.leaves == 0 {
+			c.emptyNodes--
+		}
+		n.leaves++
+	}
+	n.leaves++
+}
+
+// leafRemoved uncounts the leaf removed from the tail node n, counting any
+// nodes left with no leaf beneath them as empty until they are pruned.
+// The caller must hold the write lock.
+func (c *Cache) leafRemoved(n *node) {
+	for ; n.parent != nil; n = n.parent {
+		n.leaves--
+		if n.leaves == 0 {
+			c.emptyNodes++
+		}
+	}
+	n.leaves--
+}
+
+// closeExpiry closes and discards the expiry channel of l, if any.
+func closeExpiry(l *leaf) {
+	if l.expiry != nil {
+		close(l.expiry)
+		l.expiry = nil
+	}
+}
+
+// unlink removes l from the leaf list.
+func (c *Cache) unlink(l *leaf) {
+	if l == c.start {
+		c.start = l.next
+	}
+	if l == c.scavengeNext {
+		c.scavengeNext = l.next
+	}
+	if l.prev != nil {
+		l.prev.next = l.next
+	}
+	if l.next != nil {
+		l.next.prev = l.prev
+	}
+}
+
+// refresh resets the access time of l, making it the most recently used
+// leaf, and restarts its TTL.
+// The caller must hold the write lock.
+func (c *Cache) refresh(l *leaf) {
+	l.accessed = uint64(time.Now().UnixNano())
+	l.refreshed = l.accessed
+	c.used(l)
+}
+
+// touch records a read of l, making it the most recently used leaf and
+// extending its TTL if it has been read often enough.
+// It is safe to call under the read lock.
+func (c *Cache) touch(l *leaf) {
+	now := uint64(time.Now().UnixNano())
+	atomic.StoreUint64(&l.accessed, now)
+	c.lruMu.Lock()
+	c.used(l)
+	c.lruMu.Unlock()
+	if atomic.AddUint64(&l.accesses, 1) >= c.extendAfter {
+		atomic.StoreUint64(&l.refreshed, now)
+	}
+}
+
+// deadline returns the time l expires, in milliseconds, or noExpiry if it
+// never does.
+// It is safe to call under the read lock.
+func (c *Cache) deadline(l *leaf) uint64 {
+	ttl := l.ttl
+	if ttl == 0 {
+		ttl = c.ttl
+	}
+	if ttl == 0 || ttl == noExpiry {
+		return noExpiry
+	}
+	return atomic.LoadUint64(&l.refreshed)/1e6 + ttl
+}
+
+// expired reports whether l was last refreshed more than its TTL before now,
+// where now is in milliseconds.
+func (c *Cache) expired(l *leaf, now uint64) bool {
+	return now > c.deadline(l)
 }
 
 func (c *Cache) scavenge() {
-	for t := range c.timer.C {
-		now := uint64(t.UnixNano() / 1e6)
-		c.mu.Lock()
-		for n, e := range c.tails {
-			if now > (e.accessed/1e6)+c.ttl {
-				c.deleteNode(n)
-			}
+	buf := make([]func(), 0, expiryBatch)
+	for {
+		var t time.Time
+		select {
+		case t = <-c.timer.C:
+		case <-c.done:
+			c.timer.Stop()
+			return
 		}
-		c.timer.Reset(time.Duration(c.scavengeTime) * time.Millisecond)
-		c.mu.Unlock()
+		fired, alert := c.scavengePass(uint64(t.UnixNano()/1e6), buf)
+		for i, f := range fired {
+			start := time.Now()
+			f()
+			c.timeCallback("expiry", start)
+			fired[i] = nil // Let go of the value
+		}
+		buf = fired[:0]
+		if alert != nil {
+			alert()
+		}
+		atomic.StoreInt64(&c.lastScavenge, time.Now().UnixNano())
 	}
 }
 
-func (c *Cache) getRandomLeaf() *leaf {
-	for _, v := range c.tails {
-		return v
+// scavengePass removes the entries which had expired by now, in milliseconds,
+// and does the rest of the scavenger's housekeeping under the write lock.
+// It returns the expiry callbacks to run once the lock is released, queued in
+// buf, and the hit ratio alert, if one is due. If the callbacks fill the
+// batch, the next pass is scheduled straight away to pick up any left over.
+func (c *Cache) scavengePass(now uint64, buf []func()) ([]func(), func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fired = buf[:0]
+	c.examined = 0
+	switch c.scavengeMode {
+	case LowLatency:
+		c.scavengeSome(now)
+	default:
+		// Stop once the batch is full, as every entry after that would be
+		// examined again by the pass which follows.
+		for n, l := range c.tails {
+			if len(c.fired) >= expiryBatch {
+				break
+			}
+			c.examined++
+			if c.expired(l, now) {
+				c.expire(n)
+			}
+		}
 	}
-	return nil
+	if c.compactAbove == 0 || c.fragmentation() > c.compactAbove {
+		c.compact()
+	}
+	if c.bloom != nil && c.bloom.stale > len(c.tails)/4 {
+		c.rebuildBloom()
+	}
+	if c.saltRotation > 0 && now >= c.saltRotated+c.saltRotation {
+		c.rotateSalt() // On failure keep the current salt and retry next time
+	}
+	fired := c.fired
+	c.fired = nil
+	if len(fired) == expiryBatch {
+		c.timer.Reset(0)
+	} else {
+		c.timer.Reset(time.Duration(c.scavengeTime) * time.Millisecond)
+	}
+	return fired, c.checkHitRatio()
 }
@@ -0,0 +1,856 @@
+package hashcache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestCache returns a Cache which is closed when the test finishes.
+func newTestCache(tb testing.TB) *Cache {
+	c := NewCache("0123456789abcdef")
+	tb.Cleanup(func() { c.Close() })
+	return c
+}
+
+// waitFor fails the test if cond isn't true within a couple of seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// key returns the i'th test key.
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key-%d", i))
+}
+
+func TestWriteReadDelete(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("a")})
+	}
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	if n := c.Count(); n != 100 {
+		t.Fatalf("Count() = %d after overwriting, want 100", n)
+	}
+	for i := 0; i < 100; i++ {
+		if v, ok := c.Read(key(i)); !ok || !bytes.Equal(v, key(i)) {
+			t.Fatalf("Read(%q) = %q, %v", key(i), v, ok)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !c.Delete(key(i)) {
+			t.Fatalf("Delete(%q) = false", key(i))
+		}
+	}
+	if c.Delete(key(0)) {
+		t.Error("Delete of a deleted key = true")
+	}
+	if _, ok := c.Read(key(0)); ok {
+		t.Error("Read of a deleted key found it")
+	}
+	if n := c.NodeCount(); n != 1 {
+		t.Errorf("NodeCount() = %d after deleting everything, want 1", n)
+	}
+	if _, err := NewIterator(c).Value(); err != ErrNoRows {
+		t.Errorf("Value() on an empty cache = %v, want ErrNoRows", err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	c := newTestCache(t)
+	if !c.Reserve([]byte("k"), time.Minute) {
+		t.Fatal("first Reserve = false")
+	}
+	if c.Reserve([]byte("k"), time.Minute) {
+		t.Error("second Reserve = true")
+	}
+	if _, ok := c.Read([]byte("k")); ok {
+		t.Error("Read of a reserved key found it")
+	}
+	if v, reserved, ok := c.ReadOrReserved([]byte("k")); v != nil || !reserved || !ok {
+		t.Errorf("ReadOrReserved = %q, %v, %v, want nil, true, true", v, reserved, ok)
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	if v, reserved, ok := c.ReadOrReserved([]byte("k")); string(v) != "v" || reserved || !ok {
+		t.Errorf("ReadOrReserved after Write = %q, %v, %v, want v, false, true", v, reserved, ok)
+	}
+	if c.Reserve([]byte("k"), time.Minute) {
+		t.Error("Reserve of a written key = true")
+	}
+	if _, reserved, ok := c.ReadOrReserved([]byte("missing")); reserved || ok {
+		t.Error("ReadOrReserved found a missing key")
+	}
+}
+
+func TestReserveExpires(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	c.Reserve([]byte("k"), 10*time.Millisecond)
+	waitFor(t, "the reservation to expire", func() bool { return c.Count() == 0 })
+	if !c.Reserve([]byte("k"), time.Minute) {
+		t.Error("Reserve after the reservation expired = false")
+	}
+}
+
+func TestSaltRotation(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	if err := c.SetSaltRotation(10); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.RLock()
+	first := c.salt
+	c.mu.RUnlock()
+	if first == 0 {
+		t.Fatal("salt is 0 after enabling rotation")
+	}
+	waitFor(t, "the salt to rotate", func() bool {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.salt != first
+	})
+	for i := 0; i < 50; i++ {
+		if v, ok := c.Read(key(i)); !ok || !bytes.Equal(v, key(i)) {
+			t.Fatalf("Read(%q) after rotation = %q, %v", key(i), v, ok)
+		}
+	}
+	if err := c.SetSaltRotation(0); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.RLock()
+	salt := c.salt
+	c.mu.RUnlock()
+	if salt != 0 {
+		t.Errorf("salt = %d after disabling rotation, want 0", salt)
+	}
+	if n := c.Count(); n != 50 {
+		t.Errorf("Count() = %d, want 50", n)
+	}
+	if _, ok := c.Read(key(7)); !ok {
+		t.Error("Read after disabling rotation missed")
+	}
+}
+
+// closed reports whether ch is closed within a couple of seconds.
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(2 * time.Second):
+		return false
+	}
+}
+
+func TestExpiryChannel(t *testing.T) {
+	c := newTestCache(t)
+	if _, ok := c.ExpiryChannel([]byte("missing")); ok {
+		t.Error("ExpiryChannel of a missing key = true")
+	}
+	c.Reserve([]byte("reserved"), 0)
+	if _, ok := c.ExpiryChannel([]byte("reserved")); ok {
+		t.Error("ExpiryChannel of a reserved key = true")
+	}
+	c.Write(Row{K: []byte("deleted"), V: []byte("v")})
+	c.Write(Row{K: []byte("overwritten"), V: []byte("v")})
+	deleted, _ := c.ExpiryChannel([]byte("deleted"))
+	overwritten, _ := c.ExpiryChannel([]byte("overwritten"))
+	c.Delete([]byte("deleted"))
+	c.Write(Row{K: []byte("overwritten"), V: []byte("w")})
+	if !closed(deleted) {
+		t.Error("channel not closed by Delete")
+	}
+	if !closed(overwritten) {
+		t.Error("channel not closed by overwriting")
+	}
+
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTTL(10); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(Row{K: []byte("expired"), V: []byte("v")})
+	expired, _ := c.ExpiryChannel([]byte("expired"))
+	if !closed(expired) {
+		t.Error("channel not closed by expiry")
+	}
+}
+
+func TestValueLen(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("k"), V: []byte("value")})
+	c.Write(Row{K: []byte("empty")})
+	c.Reserve([]byte("reserved"), 0)
+	for k, want := range map[string]int{"k": 5, "empty": 0} {
+		if n, ok := c.ValueLen([]byte(k)); n != want || !ok {
+			t.Errorf("ValueLen(%q) = %d, %v, want %d, true", k, n, ok, want)
+		}
+	}
+	for _, k := range []string{"reserved", "missing"} {
+		if n, ok := c.ValueLen([]byte(k)); n != 0 || ok {
+			t.Errorf("ValueLen(%q) = %d, %v, want 0, false", k, n, ok)
+		}
+	}
+	if d, _ := c.Describe([]byte("k")); d.Accesses != 0 {
+		t.Error("ValueLen counted as an access")
+	}
+}
+
+func TestWaitEmpty(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.WaitEmpty(context.Background()); err != nil {
+		t.Fatalf("WaitEmpty on an empty cache = %v", err)
+	}
+	c.Write(Row{K: []byte("a"), V: []byte("v")})
+	c.Write(Row{K: []byte("b"), V: []byte("v")})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.WaitEmpty(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitEmpty with entries left = %v, want context.DeadlineExceeded", err)
+	}
+	done := make(chan error)
+	go func() { done <- c.WaitEmpty(context.Background()) }()
+	c.Delete([]byte("a"))
+	c.Delete([]byte("b"))
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitEmpty = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("WaitEmpty didn't return once the cache was empty")
+	}
+}
+
+func TestEvictOnFull(t *testing.T) {
+	c := newTestCache(t)
+	c.SetMaxEntries(3)
+	for i := 0; i < 3; i++ {
+		if err := c.WriteErr(Row{K: key(i), V: []byte("v")}); err != nil {
+			t.Fatalf("WriteErr below the limit = %v", err)
+		}
+	}
+	c.Read(key(0)) // key(1) is now the least recently used
+	if err := c.WriteErr(Row{K: key(3), V: []byte("v")}); err != nil {
+		t.Fatalf("WriteErr at the limit = %v", err)
+	}
+	if n := c.Count(); n != 3 {
+		t.Errorf("Count() = %d, want 3", n)
+	}
+	if c.Has(key(1)) {
+		t.Error("the least recently used entry wasn't evicted")
+	}
+	for _, i := range []int{0, 2, 3} {
+		if !c.Has(key(i)) {
+			t.Errorf("%q was evicted", key(i))
+		}
+	}
+	c.Write(Row{K: key(2), V: []byte("w")}) // Overwriting makes key(0) the oldest
+	c.Write(Row{K: key(4), V: []byte("v")})
+	if c.Has(key(0)) || !c.Has(key(2)) {
+		t.Error("eviction didn't follow the order of use")
+	}
+}
+
+func TestRejectOnFull(t *testing.T) {
+	c := newTestCache(t)
+	c.SetMaxEntries(2)
+	c.SetRejectOnFull(true)
+	c.Write(Row{K: key(0), V: []byte("v")})
+	if err := c.WriteErr(Row{K: key(1), V: []byte("v")}); err != nil {
+		t.Fatalf("WriteErr filling the cache = %v", err)
+	}
+	if err := c.WriteErr(Row{K: key(2), V: []byte("v")}); err != ErrCacheFull {
+		t.Errorf("WriteErr of a new key when full = %v, want ErrCacheFull", err)
+	}
+	if err := c.WriteErr(Row{K: key(1), V: []byte("w")}); err != nil {
+		t.Errorf("WriteErr overwriting a key when full = %v", err)
+	}
+	if n := c.Count(); n != 2 || c.Has(key(2)) {
+		t.Errorf("Count() = %d, want the rejected key left out", n)
+	}
+	c.Delete(key(0))
+	if err := c.WriteErr(Row{K: key(2), V: []byte("v")}); err != nil {
+		t.Errorf("WriteErr after making room = %v", err)
+	}
+}
+
+func TestLRUList(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 5; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Read(key(2))
+	c.Apply([]Op{{Type: OpTouch, Row: Row{K: key(0)}}})
+	c.Delete(key(4))
+	want := []string{"key-1", "key-3", "key-2", "key-0"}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var got []string
+	for l := c.oldest; l != nil; l = l.newer {
+		got = append(got, string(l.key))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("LRU list = %v, want %v", got, want)
+	}
+	got = got[:0]
+	for l := c.newest; l != nil; l = l.older {
+		got = append([]string{string(l.key)}, got...)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("LRU list backwards = %v, want %v", got, want)
+	}
+}
+
+func TestExtendThreshold(t *testing.T) {
+	c := newTestCache(t)
+	c.SetExtendThreshold(3)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	refreshed := func() uint64 {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return c.find([]byte("k")).refreshed
+	}
+	written := refreshed()
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond)
+		c.Read([]byte("k"))
+		if refreshed() != written {
+			t.Fatalf("read %d extended the TTL below the threshold", i+1)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	c.Read([]byte("k"))
+	if refreshed() == written {
+		t.Error("the read reaching the threshold didn't extend the TTL")
+	}
+
+	c.SetExtendThreshold(0)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	written = refreshed()
+	time.Sleep(time.Millisecond)
+	c.Read([]byte("k"))
+	if refreshed() == written {
+		t.Error("a read with no threshold didn't extend the TTL")
+	}
+}
+
+func TestTouchMany(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTTL(100); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(Row{K: []byte("a"), V: []byte("v")})
+	c.Write(Row{K: []byte("b"), V: []byte("v")})
+	c.Write(Row{K: []byte("c"), V: []byte("v")})
+	c.Reserve([]byte("reserved"), 0)
+	for i := 0; i < 3; i++ {
+		time.Sleep(40 * time.Millisecond)
+		if n := c.TouchMany([][]byte{[]byte("a"), []byte("b"), []byte("missing"), []byte("reserved")}); n != 2 {
+			t.Fatalf("TouchMany() = %d, want 2", n)
+		}
+	}
+	waitFor(t, "the untouched key to expire", func() bool { return !c.Has([]byte("c")) })
+	if !c.Has([]byte("a")) || !c.Has([]byte("b")) {
+		t.Error("a touched key expired")
+	}
+	if !c.Reserve([]byte("reserved"), time.Minute) {
+		t.Error("TouchMany kept a reservation from expiring")
+	}
+}
+
+func TestEmptyValues(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("nil")})
+	c.Write(Row{K: []byte("empty"), V: []byte{}})
+	for _, k := range []string{"nil", "empty"} {
+		v, ok := c.Read([]byte(k))
+		if !ok || v == nil || len(v) != 0 {
+			t.Errorf("Read(%q) = %#v, %v, want a non-nil empty value and true", k, v, ok)
+		}
+	}
+	if v, ok := c.Read([]byte("missing")); ok || v != nil {
+		t.Errorf("Read of a missing key = %#v, %v, want nil, false", v, ok)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	c.Reserve([]byte("reserved"), 0)
+	seen := map[string]bool{}
+	n := c.Drain(func(v []byte) bool {
+		seen[string(v)] = true
+		return len(seen) <= 4
+	})
+	if n != 4 {
+		t.Errorf("Drain() = %d, want 4", n)
+	}
+	if count := c.Count(); count != 7 {
+		t.Errorf("Count() = %d after draining 4, want 7", count)
+	}
+	if n := c.Drain(func([]byte) bool { return true }); n != 6 {
+		t.Errorf("Drain() of the rest = %d, want 6", n)
+	}
+	if count := c.Count(); count != 1 {
+		t.Errorf("Count() = %d, want only the reservation left", count)
+	}
+}
+
+func TestCountFunc(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: []byte{byte(i)}})
+	}
+	c.Reserve([]byte("reserved"), 0)
+	if n := c.CountFunc(func(v []byte) bool { return v[0]%2 == 0 }); n != 5 {
+		t.Errorf("CountFunc(even) = %d, want 5", n)
+	}
+	if n := c.CountFunc(func(v []byte) bool { return true }); n != 10 {
+		t.Errorf("CountFunc(all) = %d, want the reservation left out", n)
+	}
+	if n := c.Count(); n != 11 {
+		t.Errorf("CountFunc removed entries, Count() = %d", n)
+	}
+}
+
+func TestReadAndRefresh(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Stop the scavenger so the expired entry stays put
+	c.WriteWithTimer([]byte("k"), []byte("v"), 10*time.Millisecond, nil)
+	if v, stale, ok := c.ReadAndRefresh([]byte("k")); string(v) != "v" || stale || !ok {
+		t.Errorf("ReadAndRefresh of a fresh entry = %q, %v, %v", v, stale, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if v, stale, ok := c.ReadAndRefresh([]byte("k")); string(v) != "v" || !stale || !ok {
+		t.Errorf("ReadAndRefresh of an expired entry = %q, %v, %v", v, stale, ok)
+	}
+	if _, stale, _ := c.ReadAndRefresh([]byte("k")); stale {
+		t.Error("ReadAndRefresh didn't restart the TTL")
+	}
+	c.Reserve([]byte("reserved"), 0)
+	for _, k := range []string{"missing", "reserved"} {
+		if v, stale, ok := c.ReadAndRefresh([]byte(k)); v != nil || stale || ok {
+			t.Errorf("ReadAndRefresh(%q) = %q, %v, %v", k, v, stale, ok)
+		}
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	c := newTestCache(t)
+	c.SetNumericTracking(true)
+	c.Write(Row{K: []byte("Key"), V: []byte("1")})
+	c.Write(Row{K: []byte("KEY"), V: []byte("2")})
+	c.Write(Row{K: []byte("Other"), V: []byte("3")})
+	c.WriteWithDeps([]byte("derived"), []byte("v"), []byte("key"))
+	first, _ := c.ExpiryChannel([]byte("Key"))
+	second, _ := c.ExpiryChannel([]byte("KEY"))
+	c.SetKeyNormalizer(bytes.ToLower)
+
+	if n := c.Count(); n != 2 {
+		t.Errorf("Count() = %d after normalizing, want 2", n)
+	}
+	v, ok := c.Read([]byte("kEy"))
+	if !ok || (string(v) != "1" && string(v) != "2") {
+		t.Fatalf("Read(kEy) = %q, %v", v, ok)
+	}
+	dropped := first
+	if string(v) == "1" {
+		dropped = second
+	}
+	select {
+	case <-dropped:
+	default:
+		t.Error("the expiry channel of the dropped key wasn't closed")
+	}
+	if c.Has([]byte("derived")) {
+		t.Error("the entry derived from the dropped key wasn't removed")
+	}
+	if _, _, sum, count := c.NumericStats(); count != 2 || sum != 3+int64(v[0]-'0') {
+		t.Errorf("NumericStats() counts %d values summing to %d after dropping a key", count, sum)
+	}
+	if v, _ := c.Read([]byte("OTHER")); string(v) != "3" {
+		t.Errorf("Read(OTHER) = %q, want 3", v)
+	}
+	c.mu.RLock()
+	nodes := c.head.nodes()
+	c.mu.RUnlock()
+	if want := 1 + 2*c.Depth(); nodes > want {
+		t.Errorf("trie has %d nodes for 2 entries, want at most %d", nodes, want)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	c.Reserve([]byte("reserved"), 0)
+	if c.CompareAndDelete([]byte("k"), []byte("other")) {
+		t.Error("CompareAndDelete with the wrong value = true")
+	}
+	if !c.Has([]byte("k")) {
+		t.Error("CompareAndDelete with the wrong value removed the entry")
+	}
+	if c.CompareAndDelete([]byte("reserved"), nil) || c.CompareAndDelete([]byte("missing"), nil) {
+		t.Error("CompareAndDelete of a reserved or missing key = true")
+	}
+	if !c.CompareAndDelete([]byte("k"), []byte("v")) {
+		t.Error("CompareAndDelete with the right value = false")
+	}
+	if c.Has([]byte("k")) {
+		t.Error("CompareAndDelete didn't remove the entry")
+	}
+}
+
+func TestTimeSinceScavenge(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if d := c.TimeSinceScavenge(); d > 40*time.Millisecond {
+		t.Errorf("TimeSinceScavenge() = %v with a 5ms scavenge time", d)
+	}
+	c.Close()
+	time.Sleep(50 * time.Millisecond)
+	if d := c.TimeSinceScavenge(); d < 50*time.Millisecond {
+		t.Errorf("TimeSinceScavenge() = %v after stopping the scavenger", d)
+	}
+}
+
+func TestWouldCollide(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetHashFunc(func(k []byte) uint64 { return uint64(len(k)) }); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(Row{K: []byte("a"), V: []byte("v")})
+	for k, want := range map[string]bool{"a": false, "b": true, "cc": false} {
+		if got := c.WouldCollide([]byte(k)); got != want {
+			t.Errorf("WouldCollide(%q) = %v, want %v", k, got, want)
+		}
+	}
+}
+
+func TestCollisionProbability(t *testing.T) {
+	if p := collisionProbability(1, 64); p != 0 {
+		t.Errorf("collisionProbability(1, 64) = %v, want 0", p)
+	}
+	// The birthday bound: about 1.18 * 2^(bits/2) hashes for an even chance.
+	if p := collisionProbability(77163, 32); p < 0.49 || p > 0.51 {
+		t.Errorf("collisionProbability(77163, 32) = %v, want about 0.5", p)
+	}
+	if collisionProbability(1000, 64) <= collisionProbability(10, 64) {
+		t.Error("probability didn't rise with the number of hashes")
+	}
+	if collisionProbability(1000, 64) >= collisionProbability(1000, 32) {
+		t.Error("probability didn't fall with a wider hash")
+	}
+	c := newTestCache(t)
+	if p := c.CollisionProbability(); p != 0 {
+		t.Errorf("CollisionProbability() = %v for an empty cache, want 0", p)
+	}
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	if p, want := c.CollisionProbability(), collisionProbability(100, hashLen); p != want || p <= 0 {
+		t.Errorf("CollisionProbability() = %v with 100 entries, want %v", p, want)
+	}
+}
+
+func TestSetHashFunc(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	check := func(when string) {
+		t.Helper()
+		if n := c.Count(); n != 100 {
+			t.Fatalf("Count() = %d %s, want 100", n, when)
+		}
+		for i := 0; i < 100; i++ {
+			if v, ok := c.Read(key(i)); !ok || !bytes.Equal(v, key(i)) {
+				t.Fatalf("Read(%q) = %q, %v %s", key(i), v, ok, when)
+			}
+		}
+	}
+	fnv := func(k []byte) uint64 {
+		h := uint64(14695981039346656037)
+		for _, b := range k {
+			h = (h ^ uint64(b)) * 1099511628211
+		}
+		return h
+	}
+	if err := c.SetHashFunc(fnv); err != nil {
+		t.Fatal(err)
+	}
+	check("after switching to FNV")
+	if err := c.SetHashFunc(func(k []byte) uint64 { return uint64(len(k)) }); err == nil {
+		t.Fatal("SetHashFunc with a hash that collides for stored keys succeeded")
+	}
+	check("after a rejected hash function")
+	if err := c.SetHashFunc(nil); err != nil {
+		t.Fatal(err)
+	}
+	check("after restoring SipHash")
+}
+
+func TestHasMany(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 10; i += 2 {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Reserve(key(1), time.Minute)
+	keys := make([][]byte, 10)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	has := c.HasMany(keys)
+	if len(has) != len(keys) {
+		t.Fatalf("HasMany returned %d flags for %d keys", len(has), len(keys))
+	}
+	for i, ok := range has {
+		if want := i%2 == 0; ok != want {
+			t.Errorf("HasMany()[%d] = %v for %q, want %v", i, ok, keys[i], want)
+		}
+	}
+	if has := c.HasMany(nil); len(has) != 0 {
+		t.Errorf("HasMany(nil) = %v, want empty", has)
+	}
+}
+
+// benchmarkHasKeys returns a batch of keys, half of which are in a cache
+// returned by benchmarkCache.
+func benchmarkHasKeys() [][]byte {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = key(i * 2 * benchmarkKeys / len(keys))
+	}
+	return keys
+}
+
+func BenchmarkHasMany(b *testing.B) {
+	c := benchmarkCache(b)
+	keys := benchmarkHasKeys()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.HasMany(keys)
+	}
+}
+
+func BenchmarkHasLoop(b *testing.B) {
+	c := benchmarkCache(b)
+	keys := benchmarkHasKeys()
+	has := make([]bool, len(keys))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j, k := range keys {
+			has[j] = c.Has(k)
+		}
+	}
+}
+
+func TestZeroTTLNeverExpires(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Drive the scavenge passes by hand
+	if err := c.SetTTL(0); err != ErrTTLBelowScavengeTime {
+		t.Fatalf("SetTTL(0) = %v without SetZeroTTLNeverExpires, want %v", err, ErrTTLBelowScavengeTime)
+	}
+	if err := c.SetZeroTTLNeverExpires(true); err != nil {
+		t.Fatal(err)
+	}
+	c.Write(Row{K: []byte("expires"), V: []byte("v")})
+	c.WriteWithTimer([]byte("permanent"), []byte("v"), 0, nil)
+	c.Reserve([]byte("reserved"), 0)
+	now := uint64(time.Now().Add(24*time.Hour).UnixNano() / 1e6)
+	c.scavengePass(now, nil)
+	if c.Has([]byte("expires")) {
+		t.Error("entry using the cache TTL survived a day")
+	}
+	if !c.Has([]byte("permanent")) {
+		t.Error("entry written with a TTL of 0 expired")
+	}
+	if c.Reserve([]byte("reserved"), time.Minute) {
+		t.Error("reservation made with a TTL of 0 expired")
+	}
+
+	if err := c.SetTTL(0); err != nil {
+		t.Fatalf("SetTTL(0) = %v with SetZeroTTLNeverExpires", err)
+	}
+	if err := c.SetZeroTTLNeverExpires(false); err == nil {
+		t.Error("SetZeroTTLNeverExpires(false) succeeded with a cache TTL of 0")
+	}
+	c.Write(Row{K: []byte("expires"), V: []byte("v")})
+	c.scavengePass(now, nil)
+	if !c.Has([]byte("expires")) {
+		t.Error("entry using a cache TTL of 0 expired")
+	}
+	if !c.Delete([]byte("permanent")) {
+		t.Error("Delete of a permanent entry = false")
+	}
+
+	// Entries which never expire are still evicted once the cache is full.
+	c = newTestCache(t)
+	c.Close()
+	if err := c.SetZeroTTLNeverExpires(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetMaxEntries(3); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteWithTimer([]byte("permanent"), []byte("v"), 0, nil)
+	for i := 0; i < 3; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	if c.Has([]byte("permanent")) {
+		t.Error("least recently used entry with a TTL of 0 wasn't evicted")
+	}
+	for i := 0; i < 3; i++ {
+		if !c.Has(key(i)) {
+			t.Errorf("%s was evicted instead of the least recently used entry", key(i))
+		}
+	}
+}
+
+func TestReinitialize(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetTTL(60000); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetMaxEntries(5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Read(key(0))
+	c.WriteWithDeps([]byte("child"), []byte("v"), key(0)) // Evicts key(1)
+	c.Read([]byte("missing"))
+	watch, cancel := c.Watch(key(0))
+	defer cancel()
+
+	c.Reinitialize()
+	if n := c.Count(); n != 0 {
+		t.Errorf("Count() = %d after Reinitialize, want 0", n)
+	}
+	if c.hits != 0 || c.misses != 0 || c.evictions != 0 {
+		t.Errorf("hits, misses, evictions = %d, %d, %d after Reinitialize, want 0", c.hits, c.misses, c.evictions)
+	}
+	if len(c.dependents) != 0 || len(c.dependsOn) != 0 {
+		t.Errorf("%d dependents and %d dependencies left after Reinitialize", len(c.dependents), len(c.dependsOn))
+	}
+	if c.start != nil || c.newest != nil || c.oldest != nil {
+		t.Error("entry lists not empty after Reinitialize")
+	}
+	if c.ttl != 60000 || c.maxEntries != 5 {
+		t.Errorf("ttl, maxEntries = %d, %d after Reinitialize, want 60000, 5", c.ttl, c.maxEntries)
+	}
+	<-watch // The value of key(0) when the watch started
+
+	for i := 0; i < 6; i++ {
+		c.Write(Row{K: key(i), V: key(i)})
+	}
+	if n := c.Count(); n != 5 {
+		t.Errorf("Count() = %d after writing 6 entries, want the limit of 5", n)
+	}
+	if v, ok := c.Read(key(5)); !ok || !bytes.Equal(v, key(5)) {
+		t.Errorf("Read(%q) = %q, %v after Reinitialize", key(5), v, ok)
+	}
+	select {
+	case v := <-watch:
+		if !bytes.Equal(v, key(0)) {
+			t.Errorf("watcher got %q, want %q", v, key(0))
+		}
+	default:
+		t.Error("watcher registered before Reinitialize missed a write")
+	}
+}
+
+func TestValidateTimings(t *testing.T) {
+	for _, tt := range []struct {
+		ttl, scavenge uint64
+		want          error
+	}{
+		{10000, 1000, nil},
+		{1000, 1000, nil},
+		{999, 1000, ErrTTLBelowScavengeTime},
+		{0, 1000, ErrTTLBelowScavengeTime},
+		{1000, 0, ErrZeroScavengeTime},
+		{0, 0, ErrZeroScavengeTime},
+	} {
+		err := ValidateTimings(tt.ttl, tt.scavenge)
+		if err != tt.want {
+			t.Errorf("ValidateTimings(%d, %d) = %v, want %v", tt.ttl, tt.scavenge, err, tt.want)
+		}
+		c := newTestCache(t)
+		if err := c.SetScavengeTime(1); err != nil {
+			t.Fatal(err)
+		}
+		if err = c.SetTTL(tt.ttl); err == nil {
+			err = c.SetScavengeTime(tt.scavenge)
+		}
+		if (err == nil) != (tt.want == nil) {
+			t.Errorf("SetTTL(%d) then SetScavengeTime(%d) = %v, ValidateTimings returned %v", tt.ttl, tt.scavenge, err, tt.want)
+		}
+	}
+}
+
+func TestSwapKeys(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("a"), V: []byte("1")})
+	c.Write(Row{K: []byte("b"), V: []byte("2")})
+	c.WriteWithDeps([]byte("derived"), []byte("v"), []byte("a"))
+	c.Reserve([]byte("reserved"), time.Minute)
+	watch, cancel := c.Watch([]byte("b"))
+	defer cancel()
+	<-watch
+
+	if !c.SwapKeys([]byte("a"), []byte("b")) {
+		t.Fatal("SwapKeys(a, b) = false")
+	}
+	for k, want := range map[string]string{"a": "2", "b": "1"} {
+		if v, ok := c.Read([]byte(k)); !ok || string(v) != want {
+			t.Errorf("Read(%q) = %q, %v after swapping, want %q", k, v, ok, want)
+		}
+	}
+	if v := <-watch; string(v) != "1" {
+		t.Errorf("watcher of b got %q, want %q", v, "1")
+	}
+	if c.Has([]byte("derived")) {
+		t.Error("entry derived from a survived the swap")
+	}
+	for _, k := range []string{"missing", "reserved"} {
+		if c.SwapKeys([]byte("a"), []byte(k)) {
+			t.Errorf("SwapKeys(a, %q) = true", k)
+		}
+	}
+	if v, _ := c.Read([]byte("a")); string(v) != "2" {
+		t.Errorf("Read(a) = %q after a failed swap, want %q", v, "2")
+	}
+	if !c.SwapKeys([]byte("a"), []byte("a")) {
+		t.Error("SwapKeys(a, a) = false")
+	}
+}
@@ -0,0 +1,43 @@
+package hashcache
+
+import "bytes"
+
+// IdenticalWriteMode sets what Write and WriteErr do when the value written
+// is the same as the value already stored for the key.
+type IdenticalWriteMode int
+
+const (
+	// OverwriteIdentical stores the value again, exactly as if it were
+	// different. This is the default.
+	OverwriteIdentical IdenticalWriteMode = iota
+	// RefreshIdentical only restarts the entry's TTL, without notifying
+	// watchers or removing entries derived from the key.
+	RefreshIdentical
+	// SkipIdentical leaves the entry untouched, so it expires on schedule.
+	SkipIdentical
+)
+
+// SetIdenticalWriteMode sets what Write and WriteErr do when the value written
+// is byte for byte the same as the value already stored for the key.
+func (c *Cache) SetIdenticalWriteMode(m IdenticalWriteMode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identical = m
+}
+
+// writeIdentical handles a write of r according to the identical write mode,
+// returning false if r must be written as normal.
+// The caller must hold the write lock.
+func (c *Cache) writeIdentical(r Row) bool {
+	if c.identical == OverwriteIdentical {
+		return false
+	}
+	l := c.find(r.K)
+	if l == nil || l.reserved || !bytes.Equal(l.key, c.normalize(r.K)) || !bytes.Equal(*l.valuePointer, r.V) {
+		return false
+	}
+	if c.identical == RefreshIdentical {
+		c.refresh(l)
+	}
+	return true
+}
@@ -0,0 +1,48 @@
+package hashcache
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdenticalWriteMode(t *testing.T) {
+	for _, tt := range []struct {
+		mode      IdenticalWriteMode
+		name      string
+		rewritten bool // watchers notified and derived entries removed
+		refreshed bool
+	}{
+		{OverwriteIdentical, "OverwriteIdentical", true, true},
+		{RefreshIdentical, "RefreshIdentical", false, true},
+		{SkipIdentical, "SkipIdentical", false, false},
+	} {
+		c := newTestCache(t)
+		c.SetIdenticalWriteMode(tt.mode)
+		k := []byte("k")
+		c.Write(Row{K: k, V: []byte("v")})
+		c.WriteWithDeps([]byte("derived"), []byte("v"), k)
+		watch, cancel := c.Watch(k)
+		<-watch
+		refreshed := atomic.LoadUint64(&c.find(k).refreshed)
+		time.Sleep(time.Millisecond)
+
+		c.Write(Row{K: k, V: []byte("v")})
+		notified := len(watch) > 0
+		if notified != tt.rewritten {
+			t.Errorf("%s: watcher notified = %v, want %v", tt.name, notified, tt.rewritten)
+		}
+		if c.Has([]byte("derived")) == tt.rewritten {
+			t.Errorf("%s: derived entry kept = %v, want %v", tt.name, !tt.rewritten, !tt.rewritten)
+		}
+		if got := atomic.LoadUint64(&c.find(k).refreshed) != refreshed; got != tt.refreshed {
+			t.Errorf("%s: TTL restarted = %v, want %v", tt.name, got, tt.refreshed)
+		}
+
+		c.Write(Row{K: k, V: []byte("different")})
+		if v, _ := c.Read(k); string(v) != "different" {
+			t.Errorf("%s: Read(k) = %q after writing a different value", tt.name, v)
+		}
+		cancel()
+	}
+}
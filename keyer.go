@@ -0,0 +1,24 @@
+package hashcache
+
+// Keyer is implemented by application types which define their own canonical
+// byte encoding for use as a cache key, such as a struct of several fields.
+// HashKey must return the same bytes for equal keys.
+type Keyer interface {
+	HashKey() []byte
+}
+
+// WriteKeyer will add the value to the cache under the key k.HashKey(),
+// like Write.
+func (c *Cache) WriteKeyer(k Keyer, value []byte) {
+	c.Write(Row{K: k.HashKey(), V: value})
+}
+
+// ReadKeyer will read the value of the key k.HashKey(), like Read.
+func (c *Cache) ReadKeyer(k Keyer) ([]byte, bool) {
+	return c.Read(k.HashKey())
+}
+
+// DeleteKeyer will remove the entry for the key k.HashKey(), like Delete.
+func (c *Cache) DeleteKeyer(k Keyer) bool {
+	return c.Delete(k.HashKey())
+}
@@ -0,0 +1,39 @@
+package hashcache
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// userKey is a composite key with its own canonical encoding.
+type userKey struct {
+	tenant string
+	id     uint32
+}
+
+func (k userKey) HashKey() []byte {
+	b := make([]byte, 4, 4+len(k.tenant))
+	binary.BigEndian.PutUint32(b, k.id)
+	return append(b, k.tenant...)
+}
+
+func TestKeyer(t *testing.T) {
+	c := newTestCache(t)
+	c.WriteKeyer(userKey{"acme", 1}, []byte("a"))
+	c.WriteKeyer(userKey{"acme", 2}, []byte("b"))
+	if v, ok := c.ReadKeyer(userKey{"acme", 1}); !ok || string(v) != "a" {
+		t.Errorf("ReadKeyer(acme, 1) = %q, %v", v, ok)
+	}
+	if v, ok := c.Read(userKey{"acme", 2}.HashKey()); !ok || string(v) != "b" {
+		t.Errorf("Read of the encoded key = %q, %v", v, ok)
+	}
+	if _, ok := c.ReadKeyer(userKey{"other", 1}); ok {
+		t.Error("ReadKeyer found a key which wasn't written")
+	}
+	if !c.DeleteKeyer(userKey{"acme", 1}) {
+		t.Error("DeleteKeyer(acme, 1) = false")
+	}
+	if _, ok := c.ReadKeyer(userKey{"acme", 1}); ok {
+		t.Error("ReadKeyer found a deleted key")
+	}
+}
@@ -0,0 +1,66 @@
+package hashcache
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of buckets in a latency histogram. Bucket i
+// counts operations taking less than 2^i nanoseconds, so the last bucket
+// catches everything over about a second.
+const latencyBuckets = 31
+
+// LatencyHistogram counts operations by how long they took, including any
+// time spent waiting for the lock.
+// Counts[i] is the number of operations which took less than Bounds[i], and
+// at least Bounds[i-1]. The last bucket also counts anything slower.
+type LatencyHistogram struct {
+	Bounds []time.Duration
+	Counts []uint64
+}
+
+// latencyRecorder is a fixed bucket histogram which can be updated
+// concurrently with atomic increments.
+type latencyRecorder [latencyBuckets]uint64
+
+// SetLatencyTracking sets whether the time taken by each Read and Write is
+// recorded for LatencyStats. It is off by default, and costs a couple of
+// clock readings and an atomic increment per operation when on.
+func (c *Cache) SetLatencyTracking(enabled bool) {
+	var on int32
+	if enabled {
+		on = 1
+	}
+	atomic.StoreInt32(&c.trackLatency, on)
+}
+
+// LatencyStats returns histograms of the latency of "read" and "write"
+// operations recorded since the cache was created.
+func (c *Cache) LatencyStats() map[string]LatencyHistogram {
+	return map[string]LatencyHistogram{
+		"read":  c.readLatency.histogram(),
+		"write": c.writeLatency.histogram(),
+	}
+}
+
+// since records the time elapsed since start.
+func (r *latencyRecorder) since(start time.Time) {
+	i := bits.Len64(uint64(time.Since(start)))
+	if i >= latencyBuckets {
+		i = latencyBuckets - 1
+	}
+	atomic.AddUint64(&r[i], 1)
+}
+
+func (r *latencyRecorder) histogram() LatencyHistogram {
+	h := LatencyHistogram{
+		Bounds: make([]time.Duration, latencyBuckets),
+		Counts: make([]uint64, latencyBuckets),
+	}
+	for i := range r {
+		h.Bounds[i] = time.Duration(1) << uint(i)
+		h.Counts[i] = atomic.LoadUint64(&r[i])
+	}
+	return h
+}
@@ -0,0 +1,47 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyStats(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("untracked"), V: []byte("v")})
+	c.SetLatencyTracking(true)
+	for i := 0; i < 3; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Read(key(0))
+	c.Read([]byte("missing"))
+	c.SetLatencyTracking(false)
+	c.Read(key(1))
+
+	stats := c.LatencyStats()
+	for op, want := range map[string]uint64{"read": 2, "write": 3} {
+		h := stats[op]
+		if len(h.Bounds) != latencyBuckets || len(h.Counts) != latencyBuckets {
+			t.Fatalf("%s histogram has %d bounds and %d counts", op, len(h.Bounds), len(h.Counts))
+		}
+		var total uint64
+		for i, n := range h.Counts {
+			total += n
+			if i > 0 && h.Bounds[i] != 2*h.Bounds[i-1] {
+				t.Errorf("%s bounds aren't powers of 2: %v", op, h.Bounds)
+			}
+		}
+		if total != want {
+			t.Errorf("%s histogram counts %d operations, want %d", op, total, want)
+		}
+	}
+}
+
+func TestLatencyRecorder(t *testing.T) {
+	var r latencyRecorder
+	r.since(time.Now().Add(-3 * time.Nanosecond))
+	r.since(time.Now().Add(-time.Hour))
+	h := r.histogram()
+	if h.Counts[latencyBuckets-1] != 1 {
+		t.Errorf("an hour long operation wasn't counted in the last bucket: %v", h.Counts)
+	}
+}
@@ -0,0 +1,144 @@
+package hashcache
+
+import (
+	"errors"
+	"time"
+)
+
+// errLoaderPanicked is returned to callers waiting on a load whose loader
+// panicked.
+var errLoaderPanicked = errors.New("loader panicked")
+
+// loadCall is a load of a single key in progress, which other callers
+// missing the same key wait on rather than loading it again.
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	ok    bool
+	err   error
+}
+
+// GetOrWriteMulti returns the values of keys, keyed by key as a string.
+// Values found in the cache are returned straight away, and loader is called
+// once with the keys which are missing, without holding any lock. The values
+// it returns are written to the cache and included in the result.
+// If another call is already loading some of the missing keys, they aren't
+// passed to loader, and this call waits for the other one instead.
+// Keys which the loader doesn't return a value for are left out of the result.
+// If a loader returns an error, the values found so far are returned with it,
+// and nothing it returned is written.
+// If loader panics, the panic carries on up to the caller, and any calls
+// waiting for the keys it was loading return an error.
+func (c *Cache) GetOrWriteMulti(keys [][]byte, loader func(missing [][]byte) (map[string][]byte, error)) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(keys))
+	seen := make(map[string]bool, len(keys))
+	var missing []string
+	for _, key := range keys {
+		k := string(key)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if v, ok, _ := c.read(key); ok {
+			result[k] = v
+			continue
+		}
+		missing = append(missing, k)
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+	return result, c.loadMissing(missing, loader, result)
+}
+
+// loadMissing loads the missing keys with loader, or waits for calls already
+// loading them, writing the values loaded to the cache, and adding them to
+// result. It returns the first error from any loader.
+func (c *Cache) loadMissing(missing []string, loader func(missing [][]byte) (map[string][]byte, error), result map[string][]byte) error {
+	var own [][]byte
+	calls := map[string]*loadCall{}
+	waits := map[string]*loadCall{}
+	c.loadMu.Lock()
+	for _, k := range missing {
+		if call, ok := c.loading[k]; ok {
+			waits[k] = call
+			continue
+		}
+		call := &loadCall{done: make(chan struct{})}
+		c.loading[k] = call
+		calls[k] = call
+		own = append(own, []byte(k))
+	}
+	c.loadMu.Unlock()
+	var err error
+	if len(own) > 0 {
+		err = c.load(own, calls, loader, result)
+	}
+	for k, call := range waits {
+		<-call.done
+		if call.err != nil && err == nil {
+			err = call.err
+		}
+		if call.ok {
+			result[k] = call.value
+		}
+	}
+	return err
+}
+
+// load calls loader with the keys in own, whose calls are in calls, writing
+// the values loaded to the cache and adding them to result. The calls are
+// finished even if loader panics, so no waiter is left blocked, and they
+// fail with errLoaderPanicked while the panic carries on up to the caller.
+func (c *Cache) load(own [][]byte, calls map[string]*loadCall, loader func(missing [][]byte) (map[string][]byte, error), result map[string][]byte) error {
+	defer func() {
+		c.loadMu.Lock()
+		for k, call := range calls {
+			delete(c.loading, k)
+			close(call.done)
+		}
+		c.loadMu.Unlock()
+	}()
+	for _, call := range calls {
+		call.err = errLoaderPanicked // Replaced once loader returns
+	}
+	start := time.Now()
+	values, err := loader(own)
+	c.timeCallback("loader", start)
+	for k, call := range calls {
+		call.err = err
+		if err == nil {
+			call.value, call.ok = values[k]
+		}
+		if call.ok {
+			c.Write(Row{K: []byte(k), V: call.value})
+			result[k] = call.value
+		}
+	}
+	return err
+}
+
+// Invalidate will remove an entry from the cache, like Delete, returning
+// whether it was present. It exists for cache busting code, where it reads
+// better than Delete.
+func (c *Cache) Invalidate(key []byte) bool {
+	return c.Delete(key)
+}
+
+// ForceRefresh will remove the entry for key, whether or not it is present
+// or fresh, call loader without holding any lock, and write the value it
+// returns. If loader returns an error, the key is left missing and the error
+// is returned.
+func (c *Cache) ForceRefresh(key []byte, loader func() ([]byte, error)) ([]byte, error) {
+	c.Delete(key)
+	start := time.Now()
+	v, err := loader()
+	c.timeCallback("loader", start)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.WriteErr(Row{K: key, V: v}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,148 @@
+package hashcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrWriteMulti(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("cached"), V: []byte("c")})
+	var asked [][]byte
+	got, err := c.GetOrWriteMulti([][]byte{[]byte("cached"), []byte("a"), []byte("b"), []byte("a"), []byte("none")},
+		func(missing [][]byte) (map[string][]byte, error) {
+			asked = missing
+			return map[string][]byte{"a": []byte("1"), "b": []byte("2")}, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asked) != 3 {
+		t.Errorf("loader asked for %q, want the 3 distinct missing keys", asked)
+	}
+	if len(got) != 3 || string(got["cached"]) != "c" || string(got["a"]) != "1" || string(got["b"]) != "2" {
+		t.Errorf("GetOrWriteMulti() = %q", got)
+	}
+	if v, _ := c.Read([]byte("b")); string(v) != "2" {
+		t.Errorf("loaded value wasn't written, Read(b) = %q", v)
+	}
+
+	loadErr := errors.New("failed")
+	got, err = c.GetOrWriteMulti([][]byte{[]byte("a"), []byte("x")}, func([][]byte) (map[string][]byte, error) {
+		return map[string][]byte{"x": []byte("x")}, loadErr
+	})
+	if err != loadErr || len(got) != 1 || c.Has([]byte("x")) {
+		t.Errorf("GetOrWriteMulti with a failing loader = %q, %v", got, err)
+	}
+}
+
+func TestGetOrWriteMultiSharesLoads(t *testing.T) {
+	c := newTestCache(t)
+	var calls int32
+	release := make(chan struct{})
+	loader := func(missing [][]byte) (map[string][]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return map[string][]byte{"k": []byte("v")}, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := c.GetOrWriteMulti([][]byte{[]byte("k")}, loader)
+			if err != nil || string(got["k"]) != "v" {
+				t.Errorf("GetOrWriteMulti() = %q, %v", got, err)
+			}
+		}()
+	}
+	waitFor(t, "the load to start", func() bool { return atomic.LoadInt32(&calls) == 1 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("loader called %d times, want 1", n)
+	}
+}
+
+func TestGetOrWriteMultiLoaderPanics(t *testing.T) {
+	c := newTestCache(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	waited := make(chan error)
+	go func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("the loader's panic didn't reach the caller")
+			}
+		}()
+		c.GetOrWriteMulti([][]byte{[]byte("k")}, func([][]byte) (map[string][]byte, error) {
+			close(started)
+			<-release
+			panic("loader failed")
+		})
+	}()
+	<-started
+	go func() {
+		_, err := c.GetOrWriteMulti([][]byte{[]byte("k")}, func([][]byte) (map[string][]byte, error) {
+			t.Error("a second loader ran while the first was loading")
+			return nil, nil
+		})
+		waited <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the second call start waiting
+	close(release)
+	select {
+	case err := <-waited:
+		if err != errLoaderPanicked {
+			t.Errorf("waiting call returned %v, want errLoaderPanicked", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiting call was left blocked by the panic")
+	}
+	got, err := c.GetOrWriteMulti([][]byte{[]byte("k")}, func([][]byte) (map[string][]byte, error) {
+		return map[string][]byte{"k": []byte("v")}, nil
+	})
+	if err != nil || string(got["k"]) != "v" {
+		t.Errorf("GetOrWriteMulti after the panic = %q, %v", got, err)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	if !c.Invalidate([]byte("k")) || c.Has([]byte("k")) {
+		t.Error("Invalidate didn't remove the entry")
+	}
+	if c.Invalidate([]byte("k")) {
+		t.Error("Invalidate of a missing key = true")
+	}
+}
+
+func TestForceRefresh(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("k"), V: []byte("old")})
+	v, err := c.ForceRefresh([]byte("k"), func() ([]byte, error) {
+		if c.Has([]byte("k")) {
+			t.Error("the old entry was still present while loading")
+		}
+		return []byte("new"), nil
+	})
+	if err != nil || string(v) != "new" {
+		t.Errorf("ForceRefresh() = %q, %v", v, err)
+	}
+	if v, _ := c.Read([]byte("k")); string(v) != "new" {
+		t.Errorf("Read after ForceRefresh = %q, want new", v)
+	}
+
+	loadErr := errors.New("failed")
+	if _, err := c.ForceRefresh([]byte("k"), func() ([]byte, error) { return nil, loadErr }); err != loadErr {
+		t.Errorf("ForceRefresh with a failing loader = %v", err)
+	}
+	if c.Has([]byte("k")) {
+		t.Error("a failed ForceRefresh left the key present")
+	}
+}
@@ -0,0 +1,36 @@
+package hashcache
+
+// used moves l to the newest end of the LRU list, linking it in if it is new.
+// The caller must hold the write lock, or the read lock and lruMu.
+func (c *Cache) used(l *leaf) {
+	if c.newest == l {
+		return
+	}
+	c.unlinkLRU(l)
+	l.older = c.newest
+	if c.newest != nil {
+		c.newest.newer = l
+	}
+	c.newest = l
+	if c.oldest == nil {
+		c.oldest = l
+	}
+}
+
+// unlinkLRU removes l from the LRU list, if it is in it.
+// The caller must hold the write lock, or the read lock and lruMu.
+func (c *Cache) unlinkLRU(l *leaf) {
+	switch {
+	case l.newer != nil:
+		l.newer.older = l.older
+	case c.newest == l:
+		c.newest = l.older
+	}
+	switch {
+	case l.older != nil:
+		l.older.newer = l.newer
+	case c.oldest == l:
+		c.oldest = l.newer
+	}
+	l.newer, l.older = nil, nil
+}
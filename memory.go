@@ -0,0 +1,112 @@
+package hashcache
+
+import "time"
+
+// SetMemoryPressureHandler sets a function to be called by ShedMemory with the
+// number of bytes it freed. It is called without holding any lock.
+// Passing nil removes the handler.
+func (c *Cache) SetMemoryPressureHandler(fn func(freed int64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onShed = fn
+}
+
+// ShedMemory evicts the least recently used entries until at least target
+// bytes of keys and values have been freed, or nothing is left to evict. A value
+// shared with other entries by SetValueDedup only counts once the last entry
+// holding it is evicted, and reservations are left alone.
+// It returns the number of bytes actually freed, which is also passed to the
+// memory pressure handler if one is set.
+// This lets an external memory monitor drive eviction.
+func (c *Cache) ShedMemory(target int64) int64 {
+	c.mu.Lock()
+	var freed int64
+	var skipped *leaf // the most recently used reservation passed over
+	for freed < target {
+		l := c.oldest
+		if skipped != nil && c.tails[skipped.tail] == skipped {
+			l = skipped.newer
+		}
+		if l == nil {
+			break
+		}
+		if l.reserved {
+			skipped = l
+			continue
+		}
+		freed += c.freedBy(l)
+		c.evict(l.tail)
+	}
+	fn := c.onShed
+	c.mu.Unlock()
+	if fn != nil {
+		start := time.Now()
+		fn(freed)
+		c.timeCallback("memory pressure", start)
+	}
+	return freed
+}
+
+// ExpireFraction evicts about the fraction f of the entries in the cache,
+// starting with those written longest ago, and returns the number evicted.
+// It is a quicker, cruder way to free memory than ShedMemory, as it neither
+// sorts the entries nor looks at their sizes. The count is rounded down, and
+// entries removed along with one they depend on aren't counted, so it is only
+// approximate. Reservations are left alone, and f is clamped to between 0
+// and 1.
+func (c *Cache) ExpireFraction(f float64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f > 1 {
+		f = 1
+	}
+	target := int(f * float64(len(c.tails)))
+	if target <= 0 {
+		return 0
+	}
+	last := c.start
+	for last != nil && last.next != nil {
+		last = last.next
+	}
+	evicted := 0
+	for l := last; l != nil && evicted < target; {
+		prev := l.prev
+		if c.tails[l.tail] == l && !l.reserved {
+			c.evict(l.tail)
+			evicted++
+		}
+		l = prev
+	}
+	return evicted
+}
+
+// Summary returns the number of entries, the size of their keys and values in
+// bytes, and the number of nodes in the trie, including the head, all read
+// under the same lock so they are consistent with each other.
+func (c *Cache) Summary() (count int, sizeBytes int64, nodeCount int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, l := range c.tails {
+		sizeBytes += l.size()
+	}
+	return len(c.tails), sizeBytes, 1 + c.nodes
+}
+
+// freedBy returns the number of bytes removing l frees: its key, and its value
+// unless the value is shared with other entries through the slab.
+// The caller must hold the read or write lock.
+func (c *Cache) freedBy(l *leaf) int64 {
+	if l.interned && c.slabRefs(*l.valuePointer) > 1 {
+		return int64(len(l.key))
+	}
+	return l.size()
+}
+
+// size returns the number of bytes held by the key and value of l.
+func (l *leaf) size() int64 {
+	size := int64(len(l.key))
+	if l.valuePointer != nil {
+		size += int64(len(*l.valuePointer))
+	}
+	return size
+}
@@ -0,0 +1,125 @@
+package hashcache
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShedMemory(t *testing.T) {
+	c := newTestCache(t)
+	var handled int64
+	c.SetMemoryPressureHandler(func(freed int64) { handled = freed })
+	for i := 0; i < 10; i++ {
+		c.Write(Row{K: key(i), V: bytes.Repeat([]byte("v"), 10)}) // 15 bytes each
+	}
+	if freed := c.ShedMemory(40); freed != 45 {
+		t.Errorf("ShedMemory(40) = %d, want 45", freed)
+	}
+	if handled != 45 {
+		t.Errorf("handler got %d, want 45", handled)
+	}
+	if n := c.Count(); n != 7 {
+		t.Errorf("Count() = %d, want 7", n)
+	}
+	for i := 0; i < 3; i++ {
+		if _, ok := c.Read(key(i)); ok {
+			t.Errorf("%q, one of the oldest entries, wasn't shed", key(i))
+		}
+	}
+	if freed := c.ShedMemory(1000); freed != 7*15 {
+		t.Errorf("ShedMemory(1000) = %d, want %d", freed, 7*15)
+	}
+}
+
+func TestShedMemorySkipsReservations(t *testing.T) {
+	c := newTestCache(t)
+	c.Reserve([]byte("reserved"), 0)
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	if freed := c.ShedMemory(1000); freed != 2 {
+		t.Errorf("ShedMemory(1000) = %d, want 2", freed)
+	}
+	if _, reserved, _ := c.ReadOrReserved([]byte("reserved")); !reserved {
+		t.Error("ShedMemory evicted a reservation")
+	}
+}
+
+func TestShedMemorySharedValues(t *testing.T) {
+	c := newTestCache(t)
+	c.SetValueDedup(true)
+	c.Write(Row{K: []byte("a"), V: []byte("vvvv")})
+	c.Write(Row{K: []byte("b"), V: []byte("vvvv")})
+	if freed := c.ShedMemory(1); freed != 1 {
+		t.Errorf("ShedMemory of an entry sharing its value = %d, want 1", freed)
+	}
+	if freed := c.ShedMemory(1); freed != 5 {
+		t.Errorf("ShedMemory of the last entry holding a value = %d, want 5", freed)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	c := newTestCache(t)
+	const entrySize = 16 // 5 byte keys and 11 byte values
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				k := []byte(fmt.Sprintf("%d%04d", w, i%1000))
+				if i%3 == 2 {
+					c.Delete(k)
+				} else {
+					c.Write(Row{K: k, V: []byte("hello world")})
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 200; i++ {
+		count, size, nodes := c.Summary()
+		if size != int64(count)*entrySize {
+			t.Errorf("Summary() = %d entries of %d bytes, want %d bytes", count, size, count*entrySize)
+		}
+		if nodes < count+1 || nodes > 1+count*hashLen/bitsPerNode {
+			t.Errorf("Summary() = %d nodes for %d entries", nodes, count)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestExpireFraction(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Reserve([]byte("reserved"), 0)
+	if n := c.ExpireFraction(0); n != 0 {
+		t.Errorf("ExpireFraction(0) = %d, want 0", n)
+	}
+	if n := c.ExpireFraction(0.5); n != 50 {
+		t.Errorf("ExpireFraction(0.5) = %d, want 50", n)
+	}
+	if n := c.Count(); n != 51 {
+		t.Errorf("Count() = %d after expiring half, want 51", n)
+	}
+	for i := 0; i < 50; i++ {
+		if c.Has(key(i)) {
+			t.Fatalf("%q, one of the oldest half, wasn't expired", key(i))
+		}
+	}
+	if n := c.ExpireFraction(2); n != 50 {
+		t.Errorf("ExpireFraction(2) = %d, want the remaining 50", n)
+	}
+	if c.Reserve([]byte("reserved"), time.Minute) {
+		t.Error("ExpireFraction removed a reservation")
+	}
+}
@@ -0,0 +1,55 @@
+package hashcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// MergeJSON will decode the value of key as a JSON object, set each of the
+// fields in delta on it, replacing any existing field of the same name, and
+// write the result back, all under the write lock. A missing or reserved key
+// is treated as an empty object. An existing entry keeps its TTL and isn't
+// refreshed. Numbers in the existing value are kept exactly as they were, so
+// integers too large for a float64 survive the merge. It returns an error,
+// leaving the value unchanged, if the existing value isn't a JSON object, if
+// delta can't be encoded, or if the result can't be written.
+func (c *Cache) MergeJSON(key []byte, delta map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj := map[string]interface{}{}
+	l := c.find(key)
+	if l != nil && !l.reserved {
+		dec := json.NewDecoder(bytes.NewReader(*l.valuePointer))
+		dec.UseNumber()
+		if err := dec.Decode(&obj); err != nil {
+			return fmt.Errorf("existing value is not a JSON object: %v", err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return fmt.Errorf("existing value is not a JSON object: data after the object")
+		}
+		if obj == nil {
+			obj = map[string]interface{}{} // The value was null
+		}
+	}
+	for k, v := range delta {
+		obj[k] = v
+	}
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if l == nil || l.reserved {
+		_, err = c.insert(Row{K: key, V: b})
+		return err
+	}
+	ttl, refreshed := l.ttl, atomic.LoadUint64(&l.refreshed)
+	if _, err := c.insert(Row{K: key, V: b}); err != nil {
+		return err
+	}
+	l.ttl = ttl
+	atomic.StoreUint64(&l.refreshed, refreshed)
+	return nil
+}
@@ -0,0 +1,52 @@
+package hashcache
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestMergeJSON(t *testing.T) {
+	c := newTestCache(t)
+	k := []byte("k")
+	if err := c.MergeJSON(k, map[string]interface{}{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteWithTimer(k, []byte(`{"a":1,"b":"x"}`), time.Hour, nil)
+	if err := c.MergeJSON(k, map[string]interface{}{"b": "y", "c": true}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Read(k); string(v) != `{"a":1,"b":"y","c":true}` {
+		t.Errorf("Read(k) = %s after merging", v)
+	}
+	if info, _ := c.Describe(k); time.Until(info.Expires) < 50*time.Minute {
+		t.Errorf("entry expires at %v after merging, want its TTL of an hour kept", info.Expires)
+	}
+	for _, bad := range []string{`[1]`, `"s"`, `{"a":1} {}`, `{`} {
+		c.Write(Row{K: k, V: []byte(bad)})
+		if err := c.MergeJSON(k, map[string]interface{}{"a": 2}); err == nil {
+			t.Errorf("MergeJSON of %s succeeded", bad)
+		}
+		if v, _ := c.Read(k); string(v) != bad {
+			t.Errorf("Read(k) = %s after a failed merge, want %s", v, bad)
+		}
+	}
+	c.Write(Row{K: k, V: []byte(`null`)})
+	if err := c.MergeJSON(k, map[string]interface{}{"a": 2}); err != nil {
+		t.Errorf("MergeJSON of null = %v", err)
+	}
+}
+
+func TestMergeJSONLargeIntegers(t *testing.T) {
+	c := newTestCache(t)
+	k := []byte("k")
+	const big = `{"id":9007199254740993,"max":18446744073709551615,"f":1.5e300}`
+	c.Write(Row{K: k, V: []byte(big)})
+	if err := c.MergeJSON(k, map[string]interface{}{"n": json.Number("12345678901234567890")}); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := c.Read(k)
+	if want := `{"f":1.5e300,"id":9007199254740993,"max":18446744073709551615,"n":12345678901234567890}`; string(v) != want {
+		t.Errorf("Read(k) = %s after merging, want %s", v, want)
+	}
+}
@@ -0,0 +1,100 @@
+package hashcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ndjsonEntry is an entry as written by ExportNDJSON, one per line.
+// Keys and values are arbitrary bytes, so both are base64 encoded.
+type ndjsonEntry struct {
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	Created   time.Time `json:"created"`
+	Refreshed time.Time `json:"refreshed"` // the TTL runs from here
+	TTL       uint64    `json:"ttl"`       // milliseconds, 0 uses the cache TTL
+}
+
+// ExportNDJSON writes every entry in the cache to w as newline delimited
+// JSON, one object per line, for processing by other tools. Each object has
+// the base64 encoded "key" and "value", the times the entry was "created" and
+// last "refreshed", and its own "ttl" in milliseconds, which is 0 if it uses
+// the cache TTL. It can be loaded with ImportNDJSON.
+// Reservations aren't included.
+func (c *Cache) ExportNDJSON(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for l := c.start; l != nil; l = l.next {
+		if l.reserved {
+			continue
+		}
+		err := enc.Encode(ndjsonEntry{
+			Key:       l.key,
+			Value:     *l.valuePointer,
+			Created:   time.Unix(0, l.created),
+			Refreshed: time.Unix(0, int64(atomic.LoadUint64(&l.refreshed))),
+			TTL:       l.ttl,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportNDJSON reads entries written by ExportNDJSON from r and writes them
+// to the cache, keeping their creation times and the time they had left to
+// live, like Import. Entries which have already expired are skipped, as are
+// blank lines.
+// Nothing is written if any line is invalid, in which case the error gives
+// its line number.
+func (c *Cache) ImportNDJSON(r io.Reader) error {
+	br := bufio.NewReader(r)
+	var entries []ndjsonEntry
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if b = bytes.TrimSpace(b); len(b) > 0 {
+			var e ndjsonEntry
+			if jerr := json.Unmarshal(b, &e); jerr != nil {
+				return fmt.Errorf("invalid NDJSON entry on line %d: %v", line, jerr)
+			}
+			if e.Key == nil {
+				return fmt.Errorf("invalid NDJSON entry on line %d: missing key", line)
+			}
+			entries = append(entries, e)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, e := range entries {
+		if e.Refreshed.IsZero() {
+			e.Refreshed = now // Missing, so the TTL starts now
+		}
+		refreshed := uint64(e.Refreshed.UnixNano())
+		if c.expired(&leaf{refreshed: refreshed, ttl: e.TTL}, uint64(now.UnixNano()/1e6)) {
+			continue
+		}
+		if l, err := c.insert(Row{K: e.Key, V: e.Value}); err == nil {
+			l.refreshed = refreshed
+			l.ttl = e.TTL
+			if !e.Created.IsZero() {
+				l.created = e.Created.UnixNano()
+			}
+		}
+	}
+	return nil
+}
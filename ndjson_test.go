@@ -0,0 +1,72 @@
+package hashcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNDJSONRoundTrip(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("a"), V: []byte("1")})
+	c.WriteWithTimer([]byte("bin\x00\xff"), []byte{0, 1, 2}, time.Hour, nil)
+	c.Reserve([]byte("reserved"), 0)
+	var buf bytes.Buffer
+	if err := c.ExportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("ExportNDJSON wrote %d lines, want one per entry:\n%s", lines, buf.String())
+	}
+
+	d := newTestCache(t)
+	if err := d.ImportNDJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.Count(); n != 2 {
+		t.Errorf("Count() = %d after importing, want 2", n)
+	}
+	for _, k := range [][]byte{[]byte("a"), []byte("bin\x00\xff")} {
+		want, _ := c.Describe(k)
+		got, ok := d.Describe(k)
+		if !ok {
+			t.Fatalf("%q wasn't imported", k)
+		}
+		if !got.Created.Equal(want.Created) || !got.Expires.Equal(want.Expires) {
+			t.Errorf("%q imported with Created %v and Expires %v, want %v and %v", k, got.Created, got.Expires, want.Created, want.Expires)
+		}
+	}
+	if v, _ := d.Read([]byte("bin\x00\xff")); !bytes.Equal(v, []byte{0, 1, 2}) {
+		t.Errorf("binary value imported as %q", v)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	c := newTestCache(t)
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	input := `{"key":"YQ==","value":"MQ=="}` + "\n\n" + // a = 1, the TTL starting now
+		`{"key":"Yg==","value":"Mg==","refreshed":"` + expired + `","ttl":1000}` + "\n"
+	if err := c.ImportNDJSON(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Read([]byte("a")); string(v) != "1" {
+		t.Errorf("Read(a) = %q, want 1", v)
+	}
+	if c.Has([]byte("b")) {
+		t.Error("ImportNDJSON loaded an expired entry")
+	}
+	for _, bad := range []string{
+		`{"key":"YQ==","value":"MQ=="}` + "\n" + `not json`,
+		`{"value":"MQ=="}`,
+	} {
+		d := newTestCache(t)
+		err := d.ImportNDJSON(strings.NewReader(bad))
+		if err == nil || !strings.Contains(err.Error(), "line") {
+			t.Errorf("ImportNDJSON(%q) = %v, want an error giving the line", bad, err)
+		}
+		if n := d.Count(); n != 0 {
+			t.Errorf("ImportNDJSON(%q) wrote %d entries", bad, n)
+		}
+	}
+}
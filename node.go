@@ -0,0 +1,55 @@
+package hashcache
+
+// newNode returns a node with room for every child, below parent.
+func newNode(parent *node) *node {
+	return &node{
+		parent:   parent,
+		children: make([]*node, 1<<bitsPerNode),
+	}
+}
+
+// child returns the child of n at index i, or nil if there isn't one.
+func (n *node) child(i uint64) *node {
+	return n.children[i]
+}
+
+// setChild sets the child of n at index i, removing it if child is nil.
+func (n *node) setChild(i uint64, child *node) {
+	switch old := n.children[i]; {
+	case old == nil && child != nil:
+		n.count++
+	case old != nil && child == nil:
+		n.count--
+	}
+	n.children[i] = child
+}
+
+// removeChild removes child from the children of n, returning false if it
+// wasn't one of them.
+func (n *node) removeChild(child *node) bool {
+	for i, c := range n.children {
+		if c == child {
+			n.setChild(uint64(i), nil)
+			return true
+		}
+	}
+	return false
+}
+
+// each calls fn for each child of n in index order.
+func (n *node) each(fn func(i uint64, child *node)) {
+	for i, c := range n.children {
+		if c != nil {
+			fn(uint64(i), c)
+		}
+	}
+}
+
+// nodes returns the number of nodes in the subtrie rooted at n, including n.
+func (n *node) nodes() int {
+	count := 1
+	n.each(func(_ uint64, child *node) {
+		count += child.nodes()
+	})
+	return count
+}
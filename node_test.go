@@ -0,0 +1,93 @@
+package hashcache
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestNodeChildren(t *testing.T) {
+	n := newNode(nil)
+	a, b := newNode(n), newNode(n)
+	n.setChild(3, a)
+	n.setChild(9, b)
+	n.setChild(9, b)
+	if n.count != 2 {
+		t.Fatalf("count = %d after setting 2 children, want 2", n.count)
+	}
+	var got []uint64
+	n.each(func(i uint64, child *node) { got = append(got, i) })
+	if len(got) != 2 || got[0] != 3 || got[1] != 9 {
+		t.Errorf("each visited %v, want [3 9]", got)
+	}
+	if !n.removeChild(a) {
+		t.Error("removeChild of a child = false")
+	}
+	if n.removeChild(a) {
+		t.Error("removeChild of a removed child = true")
+	}
+	if n.count != 1 || n.child(3) != nil || n.child(9) != b {
+		t.Errorf("after removeChild count = %d, child(3) = %p, child(9) = %p", n.count, n.child(3), n.child(9))
+	}
+	if nodes := n.nodes(); nodes != 2 {
+		t.Errorf("nodes() = %d, want 2", nodes)
+	}
+}
+
+// benchmarkKeys is the number of distinct keys used by the trie benchmarks.
+const benchmarkKeys = 1 << 16
+
+func benchmarkCache(b *testing.B) *Cache {
+	c := newTestCache(b)
+	for i := 0; i < benchmarkKeys; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	return c
+}
+
+func BenchmarkWrite(b *testing.B) {
+	c := newTestCache(b)
+	v := []byte("v")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Write(Row{K: key(i % benchmarkKeys), V: v})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	c := benchmarkCache(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Read(key(i % benchmarkKeys))
+	}
+}
+
+func BenchmarkWriteDelete(b *testing.B) {
+	c := benchmarkCache(b)
+	v := []byte("v")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := key(benchmarkKeys + i)
+		c.Write(Row{K: k, V: v})
+		c.Delete(k)
+	}
+}
+
+// BenchmarkMemory reports the heap held by a cache of benchmarkKeys entries,
+// per key, which is mostly trie nodes.
+func BenchmarkMemory(b *testing.B) {
+	var before, after runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		c := benchmarkCache(b)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/benchmarkKeys, "B/key")
+		for j := 0; j < benchmarkKeys; j++ {
+			c.Delete(key(j)) // So it doesn't expire while the next cache is measured
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package hashcache
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"sync/atomic"
+)
+
+// numericStats holds running aggregates of the integer values in the cache.
+type numericStats struct {
+	count int
+	min   int64
+	max   int64
+	sumHi int64 // the sum is kept 128 bits wide so it can't overflow
+	sumLo uint64
+	stale bool // the min or max was removed and must be found again
+}
+
+// SetNumericTracking sets whether the aggregates returned by NumericStats are
+// kept up to date as values are written, incremented and removed, rather than
+// computed from every entry on each call. Tracking parses every value written,
+// so it is off by default. Enabling it computes the aggregates of the
+// existing entries.
+func (c *Cache) SetNumericTracking(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case enabled && c.numeric == nil:
+		c.numeric = c.numericWalk()
+	case !enabled:
+		c.numeric = nil
+	}
+}
+
+// NumericStats returns the minimum, maximum and sum of all values in the cache
+// which hold a base 10 integer, such as "42" or "-7", along with the number
+// of such values. Values which aren't integers are ignored. A sum beyond the
+// range of an int64 is clamped to it.
+// With SetNumericTracking the aggregates are read straight from the running
+// totals, except that removing the minimum or maximum value means checking
+// every entry for the new one on the next call. Without it every entry is
+// checked on each call.
+func (c *Cache) NumericStats() (min, max, sum int64, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.numeric
+	switch {
+	case s == nil:
+		s = c.numericWalk()
+	case s.stale:
+		fresh := c.numericWalk()
+		s.min, s.max, s.stale = fresh.min, fresh.max, false
+	}
+	return s.min, s.max, s.sum(), s.count
+}
+
+// numericWalk returns the aggregates of every integer value in the cache.
+// The caller must hold the read or write lock.
+func (c *Cache) numericWalk() *numericStats {
+	s := &numericStats{}
+	for _, l := range c.tails {
+		if l.reserved {
+			continue
+		}
+		if v, ok := parseInt(*l.valuePointer); ok {
+			s.add(v)
+		}
+	}
+	return s
+}
+
+// trackNumeric updates the running aggregates, if they are tracked, for the
+// value old leaving the cache and the value new entering it, either of which
+// may be nil. The caller must hold the write lock.
+func (c *Cache) trackNumeric(old, new *[]byte) {
+	if c.numeric == nil {
+		return
+	}
+	if old != nil {
+		if v, ok := parseInt(*old); ok {
+			c.numeric.remove(v)
+		}
+	}
+	if new != nil {
+		if v, ok := parseInt(*new); ok {
+			c.numeric.add(v)
+		}
+	}
+}
+
+func (s *numericStats) add(v int64) {
+	var carry uint64
+	s.sumLo, carry = bits.Add64(s.sumLo, uint64(v), 0)
+	s.sumHi += int64(carry)
+	if v < 0 {
+		s.sumHi-- // Sign extend v
+	}
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+}
+
+func (s *numericStats) remove(v int64) {
+	var borrow uint64
+	s.sumLo, borrow = bits.Sub64(s.sumLo, uint64(v), 0)
+	s.sumHi -= int64(borrow)
+	if v < 0 {
+		s.sumHi++
+	}
+	if s.count--; s.count == 0 {
+		*s = numericStats{}
+	} else if v == s.min || v == s.max {
+		s.stale = true
+	}
+}
+
+// sum returns the sum, clamped to the range of an int64.
+func (s *numericStats) sum() int64 {
+	switch {
+	case s.sumHi == 0 && s.sumLo <= math.MaxInt64, s.sumHi == -1 && s.sumLo > math.MaxInt64:
+		return int64(s.sumLo)
+	case s.sumHi < 0:
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// IncrementCapped adds delta to the integer value of key, stored in base 10
+// like NumericStats expects, without letting it rise above cap.
+// A missing key starts at 0, and an existing entry keeps its TTL and isn't
+// refreshed, as with MergeJSON. It returns the new value and true if delta was
+// added in full, or false if the value was clamped to cap, which happens
+// whenever the sum would be above it, whatever the sign of delta. A sum below
+// the smallest int64 is clamped to it, also returning false. If the key holds
+// a value which isn't an integer, or can't be added because the cache is full,
+// it is left unchanged and 0 and false are returned.
+func (c *Cache) IncrementCapped(key []byte, delta, cap int64) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var v int64
+	l := c.find(key)
+	if l != nil && !l.reserved {
+		var ok bool
+		if v, ok = parseInt(*l.valuePointer); !ok {
+			return 0, false
+		}
+	}
+	n, applied := v+delta, true
+	overflow := (delta > 0) != (n > v) // The sum wrapped round
+	switch {
+	case overflow && delta < 0:
+		n, applied = math.MinInt64, false
+	case overflow || n > cap:
+		n, applied = cap, false
+	}
+	row := Row{K: key, V: strconv.AppendInt(nil, n, 10)}
+	if l == nil || l.reserved {
+		if _, err := c.insert(row); err != nil {
+			return 0, false
+		}
+		return n, applied
+	}
+	ttl, refreshed := l.ttl, atomic.LoadUint64(&l.refreshed)
+	if _, err := c.insert(row); err != nil {
+		return 0, false
+	}
+	l.ttl = ttl
+	atomic.StoreUint64(&l.refreshed, refreshed)
+	return n, applied
+}
+
+// parseInt parses b as a base 10 int64, returning false if it isn't one.
+func parseInt(b []byte) (int64, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(string(b), 10, 64)
+	return v, err == nil
+}
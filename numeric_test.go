@@ -0,0 +1,160 @@
+package hashcache
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNumericStats(t *testing.T) {
+	for _, tracked := range []bool{false, true} {
+		t.Run(strconv.FormatBool(tracked), func(t *testing.T) {
+			c := newTestCache(t)
+			c.SetNumericTracking(tracked)
+			for i, v := range []string{"5", "-3", "12", "not a number", "", "7"} {
+				c.Write(Row{K: key(i), V: []byte(v)})
+			}
+			checkNumericStats(t, c, -3, 12, 21, 4)
+
+			c.Write(Row{K: key(2), V: []byte("1")}) // Overwrite the max
+			checkNumericStats(t, c, -3, 7, 10, 4)
+			c.Delete(key(1)) // Delete the min
+			checkNumericStats(t, c, 1, 7, 13, 3)
+			if _, ok := c.IncrementCapped(key(0), 10, 100); !ok {
+				t.Fatal("IncrementCapped = false")
+			}
+			checkNumericStats(t, c, 1, 15, 23, 3)
+			c.Write(Row{K: key(3), V: []byte("-20")}) // Replace a non-number
+			checkNumericStats(t, c, -20, 15, 3, 4)
+			c.mu.Lock()
+			c.evict(c.find(key(3)).tail)
+			c.mu.Unlock()
+			checkNumericStats(t, c, 1, 15, 23, 3)
+			c.Reserve(key(10), 0)
+			checkNumericStats(t, c, 1, 15, 23, 3)
+			c.Reinitialize()
+			checkNumericStats(t, c, 0, 0, 0, 0)
+		})
+	}
+}
+
+func TestNumericStatsOverflow(t *testing.T) {
+	c := newTestCache(t)
+	c.SetNumericTracking(true)
+	max := strconv.FormatInt(math.MaxInt64, 10)
+	c.Write(Row{K: key(0), V: []byte(max)})
+	c.Write(Row{K: key(1), V: []byte(max)})
+	if _, _, sum, _ := c.NumericStats(); sum != math.MaxInt64 {
+		t.Errorf("sum = %d, want it clamped to %d", sum, int64(math.MaxInt64))
+	}
+	c.Write(Row{K: key(2), V: []byte("-1")})
+	c.Delete(key(1))
+	checkNumericStats(t, c, -1, math.MaxInt64, math.MaxInt64-1, 2)
+
+	min := strconv.FormatInt(math.MinInt64, 10)
+	c.Write(Row{K: key(3), V: []byte(min)})
+	c.Write(Row{K: key(4), V: []byte(min)})
+	c.Write(Row{K: key(5), V: []byte(min)})
+	if _, _, sum, _ := c.NumericStats(); sum != math.MinInt64 {
+		t.Errorf("sum = %d, want it clamped to %d", sum, int64(math.MinInt64))
+	}
+}
+
+func TestSetNumericTracking(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: key(0), V: []byte("4")})
+	c.Write(Row{K: key(1), V: []byte("9")})
+	c.SetNumericTracking(true)
+	checkNumericStats(t, c, 4, 9, 13, 2)
+	c.SetNumericTracking(false)
+	c.Write(Row{K: key(2), V: []byte("2")})
+	checkNumericStats(t, c, 2, 9, 15, 3)
+}
+
+func checkNumericStats(t *testing.T, c *Cache, min, max, sum int64, count int) {
+	t.Helper()
+	gotMin, gotMax, gotSum, gotCount := c.NumericStats()
+	if gotMin != min || gotMax != max || gotSum != sum || gotCount != count {
+		t.Errorf("NumericStats() = %d, %d, %d, %d, want %d, %d, %d, %d",
+			gotMin, gotMax, gotSum, gotCount, min, max, sum, count)
+	}
+}
+
+func TestIncrementCapped(t *testing.T) {
+	c := newTestCache(t)
+	for _, tt := range []struct {
+		delta, want int64
+		applied     bool
+	}{
+		{3, 3, true},
+		{4, 7, true},
+		{5, 10, false},
+		{1, 10, false},
+		{-4, 6, true},
+	} {
+		if n, applied := c.IncrementCapped([]byte("k"), tt.delta, 10); n != tt.want || applied != tt.applied {
+			t.Errorf("IncrementCapped(%d) = %d, %v, want %d, %v", tt.delta, n, applied, tt.want, tt.applied)
+		}
+	}
+	if v, _ := c.Read([]byte("k")); string(v) != "6" {
+		t.Errorf("Read(k) = %q, want 6", v)
+	}
+	c.Write(Row{K: []byte("text"), V: []byte("abc")})
+	if n, applied := c.IncrementCapped([]byte("text"), 1, 10); n != 0 || applied {
+		t.Errorf("IncrementCapped of a non-integer = %d, %v", n, applied)
+	}
+	if v, _ := c.Read([]byte("text")); string(v) != "abc" {
+		t.Errorf("IncrementCapped changed a non-integer to %q", v)
+	}
+}
+
+func TestIncrementCappedBounds(t *testing.T) {
+	for _, tt := range []struct {
+		name              string
+		start, delta, cap int64
+		want              int64
+		applied           bool
+	}{
+		{"positive", 5, 3, 10, 8, true},
+		{"up to the cap", 5, 5, 10, 10, true},
+		{"past the cap", 5, 6, 10, 10, false},
+		{"negative", 5, -3, 10, 2, true},
+		{"below zero", 0, -5, 10, -5, true},
+		{"negative still above the cap", 20, -3, 10, 10, false},
+		{"zero above the cap", 20, 0, 10, 10, false},
+		{"below a negative cap", -10, 3, -5, -7, true},
+		{"past a negative cap", 0, 1, -5, -5, false},
+		{"up to the largest int64", math.MaxInt64 - 1, 1, math.MaxInt64, math.MaxInt64, true},
+		{"overflowing", math.MaxInt64 - 1, 5, math.MaxInt64, math.MaxInt64, false},
+		{"overflowing a negative cap", 0, math.MaxInt64, -10, -10, false},
+		{"down to the smallest int64", math.MinInt64 + 1, -1, 10, math.MinInt64, true},
+		{"underflowing", math.MinInt64 + 1, -5, 10, math.MinInt64, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t)
+			c.Write(Row{K: []byte("k"), V: []byte(strconv.FormatInt(tt.start, 10))})
+			n, applied := c.IncrementCapped([]byte("k"), tt.delta, tt.cap)
+			if n != tt.want || applied != tt.applied {
+				t.Errorf("IncrementCapped(%d, %d) from %d = %d, %v, want %d, %v", tt.delta, tt.cap, tt.start, n, applied, tt.want, tt.applied)
+			}
+			if v, _ := c.Read([]byte("k")); string(v) != strconv.FormatInt(tt.want, 10) {
+				t.Errorf("Read(k) = %q, want %d", v, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncrementCappedKeepsTTL(t *testing.T) {
+	c := newTestCache(t)
+	c.WriteWithTimer([]byte("k"), []byte("1"), time.Hour, nil)
+	before, _ := c.Describe([]byte("k"))
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := c.IncrementCapped([]byte("k"), 1, 10); n != 2 {
+		t.Fatalf("IncrementCapped() = %d, want 2", n)
+	}
+	after, _ := c.Describe([]byte("k"))
+	if !after.Expires.Equal(before.Expires) {
+		t.Errorf("IncrementCapped moved the expiry from %v to %v", before.Expires, after.Expires)
+	}
+}
@@ -0,0 +1,190 @@
+package hashcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// snapshotMagic starts every snapshot written by Export, followed by
+	// the version of the snapshot format and a newline.
+	snapshotMagic = "hashcache"
+	// snapshotVersion is the version of the snapshot format written by
+	// Export. Version 1 recorded how long before the snapshot each entry
+	// was refreshed, rather than when, so it lost the time a persistent
+	// cache spent closed. Version 2 didn't record when each entry was
+	// created, so imported entries were created when they were loaded.
+	snapshotVersion = 3
+)
+
+// ErrBadSnapshot means that the data passed to Import isn't a valid snapshot
+var ErrBadSnapshot = errors.New("invalid cache snapshot")
+
+// NewCachePersistent will return a pointer to a newly instantiated Cache,
+// like NewCache, which is loaded from the snapshot in the file at path and
+// saves a snapshot back to it when Close is called.
+// If the file is missing or can't be read, a warning is logged and the cache
+// starts empty. Entries keep the time they have left to live, measured by the
+// TTL of the new cache if they used the cache TTL, and those which expired
+// while the cache was closed aren't loaded.
+func NewCachePersistent(hashKey, path string) (*Cache, error) {
+	c := NewCache(hashKey)
+	c.snapshotPath = path
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("hashcache: starting with an empty cache: %v", err)
+		return c, nil
+	}
+	defer f.Close()
+	if err := c.Import(f); err != nil {
+		log.Printf("hashcache: starting with an empty cache: unable to load %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Export writes a snapshot of every entry in the cache to w, which can be
+// loaded with Import. Reservations aren't included.
+func (c *Cache) Export(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "%s%d\n", snapshotMagic, snapshotVersion); err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	for l := c.start; l != nil; l = l.next {
+		if l.reserved {
+			continue
+		}
+		for _, b := range [][]byte{l.key, *l.valuePointer} {
+			bw.Write(buf[:binary.PutUvarint(buf, uint64(len(b)))])
+			bw.Write(b)
+		}
+		bw.Write(buf[:binary.PutUvarint(buf, l.ttl)])
+		bw.Write(buf[:binary.PutUvarint(buf, atomic.LoadUint64(&l.refreshed))])
+		bw.Write(buf[:binary.PutVarint(buf, l.created)])
+	}
+	return bw.Flush()
+}
+
+// Import reads a snapshot written by Export from r and writes its entries to
+// the cache, keeping their creation times and the time they had left to live.
+// Entries which have already expired are skipped.
+// Nothing is written if the snapshot is invalid, in which case ErrBadSnapshot
+// is returned.
+func (c *Cache) Import(r io.Reader) error {
+	type entry struct {
+		row            Row
+		ttl, refreshed uint64
+		created        int64 // nanoseconds, 0 if the snapshot doesn't record it
+	}
+	br := bufio.NewReader(r)
+	version, err := readSnapshotVersion(br)
+	if err != nil {
+		return err
+	}
+	now := uint64(time.Now().UnixNano())
+	var entries []entry
+	for {
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+		var e entry
+		var err error
+		if e.row.K, err = readBytes(br); err != nil {
+			return err
+		}
+		if e.row.V, err = readBytes(br); err != nil {
+			return err
+		}
+		if e.ttl, err = binary.ReadUvarint(br); err != nil {
+			return ErrBadSnapshot
+		}
+		if e.refreshed, err = binary.ReadUvarint(br); err != nil {
+			return ErrBadSnapshot
+		}
+		if version == 1 {
+			e.refreshed = now - e.refreshed*1e6 // Version 1 stored the age in milliseconds
+		}
+		if version >= 3 {
+			if e.created, err = binary.ReadVarint(br); err != nil {
+				return ErrBadSnapshot
+			}
+		}
+		entries = append(entries, e)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		if c.expired(&leaf{refreshed: e.refreshed, ttl: e.ttl}, now/1e6) {
+			continue
+		}
+		if l, err := c.insert(e.row); err == nil {
+			l.refreshed = e.refreshed
+			l.ttl = e.ttl
+			if e.created != 0 {
+				l.created = e.created
+			}
+		}
+	}
+	return nil
+}
+
+// readSnapshotVersion reads the magic at the start of a snapshot from r,
+// returning the version of the snapshot format, or ErrBadSnapshot if it isn't
+// a version Import understands.
+func readSnapshotVersion(r *bufio.Reader) (int, error) {
+	magic := make([]byte, len(snapshotMagic)+2)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic[:len(snapshotMagic)]) != snapshotMagic || magic[len(magic)-1] != '\n' {
+		return 0, ErrBadSnapshot
+	}
+	version := int(magic[len(snapshotMagic)] - '0')
+	if version < 1 || version > snapshotVersion {
+		return 0, ErrBadSnapshot
+	}
+	return version, nil
+}
+
+// save writes a snapshot of the cache to the file at path, replacing it
+// only once the snapshot is complete.
+func (c *Cache) save(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := c.Export(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readBytes reads a length prefixed byte slice from r.
+// The slice grows as it is read, so a corrupt length can't cause a huge
+// allocation up front.
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > math.MaxInt64 {
+		return nil, ErrBadSnapshot
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSnapshot, err)
+	}
+	return buf.Bytes(), nil
+}
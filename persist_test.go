@@ -0,0 +1,136 @@
+package hashcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewCachePersistent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	c, err := NewCachePersistent("0123456789abcdef", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := c.Count(); n != 0 {
+		t.Fatalf("Count() = %d for a missing snapshot, want 0", n)
+	}
+	c.Write(Row{K: []byte("a"), V: []byte("1")})
+	c.Write(Row{K: []byte("b"), V: []byte("2")})
+	c.WriteWithTimer([]byte("short"), []byte("3"), 50*time.Millisecond, nil)
+	c.Reserve([]byte("reserved"), 0)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+
+	c, err = NewCachePersistent("0123456789abcdef", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for k, want := range map[string]string{"a": "1", "b": "2"} {
+		if v, _ := c.Read([]byte(k)); string(v) != want {
+			t.Errorf("Read(%q) after reloading = %q, want %q", k, v, want)
+		}
+	}
+	if n := c.Count(); n != 2 {
+		t.Errorf("Count() after reloading = %d, want the expired entry and reservation left out", n)
+	}
+}
+
+func TestImportBadSnapshot(t *testing.T) {
+	c := newTestCache(t)
+	var buf bytes.Buffer
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	if err := c.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range [][]byte{
+		[]byte("not a snapshot"),
+		buf.Bytes()[:buf.Len()-3],
+	} {
+		c := newTestCache(t)
+		if err := c.Import(bytes.NewReader(data)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("Import(%q) = %v, want ErrBadSnapshot", data, err)
+		}
+		if n := c.Count(); n != 0 {
+			t.Errorf("Import of a bad snapshot wrote %d entries", n)
+		}
+	}
+}
+
+func TestImportVersion1(t *testing.T) {
+	c := newTestCache(t)
+	snapshot := "hashcache1\n\x01k\x01v\x00\x00" + // Fresh
+		"\x01x\x01v\x00\xa0\x9c\x01" // 20000 milliseconds old, past the cache TTL
+	if err := c.Import(bytes.NewReader([]byte(snapshot))); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.Read([]byte("k")); string(v) != "v" {
+		t.Errorf("Read of a version 1 entry = %q, want v", v)
+	}
+	if c.Has([]byte("x")) {
+		t.Error("Import loaded an expired version 1 entry")
+	}
+	if err := c.Import(bytes.NewReader([]byte("hashcache9\n"))); err != ErrBadSnapshot {
+		t.Errorf("Import of an unknown version = %v, want ErrBadSnapshot", err)
+	}
+}
+
+func TestExportImportCreated(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("old"), V: []byte("v")})
+	time.Sleep(2 * time.Millisecond)
+	c.Write(Row{K: []byte("new"), V: []byte("v")})
+	oldest, newest, _ := c.TimeRange()
+	var buf bytes.Buffer
+	if err := c.Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	d := newTestCache(t)
+	if err := d.Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if o, n, ok := d.TimeRange(); !ok || !o.Equal(oldest) || !n.Equal(newest) {
+		t.Errorf("TimeRange() = %v, %v, %v after importing, want %v, %v", o, n, ok, oldest, newest)
+	}
+	for _, k := range []string{"old", "new"} {
+		want, _ := c.Describe([]byte(k))
+		if got, _ := d.Describe([]byte(k)); !got.Created.Equal(want.Created) {
+			t.Errorf("Created of %q = %v after importing, want %v", k, got.Created, want.Created)
+		}
+	}
+}
+
+func TestImportVersion2(t *testing.T) {
+	c := newTestCache(t)
+	before := time.Now()
+	var snapshot bytes.Buffer
+	snapshot.WriteString("hashcache2\n\x01k\x01v\x00")
+	buf := make([]byte, binary.MaxVarintLen64)
+	snapshot.Write(buf[:binary.PutUvarint(buf, uint64(before.UnixNano()))])
+	if err := c.Import(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := c.Describe([]byte("k"))
+	if !ok {
+		t.Fatal("Import didn't load a version 2 entry")
+	}
+	if info.Created.Before(before) {
+		t.Errorf("Created = %v for a version 2 entry, want the time it was loaded", info.Created)
+	}
+}
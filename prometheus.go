@@ -0,0 +1,42 @@
+package hashcache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// WritePrometheus writes the cache metrics to w in the Prometheus text
+// exposition format, with each metric name starting with prefix.
+// Evictions count entries removed by expiry or to make room, but not
+// entries removed by Delete.
+func (c *Cache) WritePrometheus(w io.Writer, prefix string) error {
+	c.mu.RLock()
+	count := len(c.tails)
+	var size int64
+	for _, l := range c.tails {
+		size += l.size()
+	}
+	c.mu.RUnlock()
+	if prefix != "" {
+		prefix += "_"
+	}
+	bw := bufio.NewWriter(w)
+	metrics := []struct {
+		name, kind, help string
+		value            interface{}
+	}{
+		{"entries", "gauge", "Number of entries in the cache.", count},
+		{"hits_total", "counter", "Number of reads which found a value.", atomic.LoadUint64(&c.hits)},
+		{"misses_total", "counter", "Number of reads which didn't find a value.", atomic.LoadUint64(&c.misses)},
+		{"evictions_total", "counter", "Number of entries expired or evicted.", atomic.LoadUint64(&c.evictions)},
+		{"size_bytes", "gauge", "Size of the keys and values in the cache.", size},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(bw, "# HELP %s%s %s\n", prefix, m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s%s %s\n", prefix, m.name, m.kind)
+		fmt.Fprintf(bw, "%s%s %d\n", prefix, m.name, m.value)
+	}
+	return bw.Flush()
+}
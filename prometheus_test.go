@@ -0,0 +1,40 @@
+package hashcache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("key"), V: []byte("value")})
+	c.Read([]byte("key"))
+	c.Read([]byte("missing"))
+	c.Read([]byte("missing"))
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf, "app_cache"); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# HELP app_cache_entries Number of entries in the cache.\n",
+		"# TYPE app_cache_entries gauge\n",
+		"app_cache_entries 1\n",
+		"# TYPE app_cache_hits_total counter\n",
+		"app_cache_hits_total 1\n",
+		"app_cache_misses_total 2\n",
+		"app_cache_evictions_total 0\n",
+		"app_cache_size_bytes 8\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("WritePrometheus output has no line %q:\n%s", line, out)
+		}
+	}
+
+	buf.Reset()
+	c.WritePrometheus(&buf, "")
+	if !strings.Contains(buf.String(), "\nentries 1\n") {
+		t.Errorf("WritePrometheus with no prefix:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,104 @@
+package hashcache
+
+import "fmt"
+
+// SetLazyPrune sets whether removing an entry leaves its trie nodes in place
+// for the next scavenge to reclaim, rather than pruning them straight away.
+// This takes the walk up the trie off Delete and the other removals, at the cost
+// of holding on to empty nodes for up to the scavenge time, or longer with
+// SetCompactThreshold. Turning it off prunes any nodes still waiting.
+func (c *Cache) SetLazyPrune(lazy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !lazy {
+		c.compact()
+	}
+	c.lazyPrune = lazy
+}
+
+// NodeCount returns the number of nodes in the trie, including the head.
+func (c *Cache) NodeCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return 1 + c.nodes
+}
+
+// EmptyNodeCount returns the number of nodes in the trie which lead to no
+// entries, and are waiting to be reclaimed after lazy pruning.
+func (c *Cache) EmptyNodeCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.settle()
+	return c.emptyNodes
+}
+
+// SetCompactThreshold sets the fragmentation above which a scavenge reclaims
+// the nodes left behind by lazy pruning, so that they are reclaimed in fewer,
+// larger batches rather than on every scavenge. It must be between 0 and 1,
+// and 0, the default, reclaims them on every scavenge.
+func (c *Cache) SetCompactThreshold(ratio float64) error {
+	if ratio < 0 || ratio > 1 {
+		return fmt.Errorf("compact threshold must be between 0 and 1")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compactAbove = ratio
+	return nil
+}
+
+// Fragmentation returns the fraction of the nodes in the trie which lead to
+// no entries, and are waiting to be reclaimed after lazy pruning.
+func (c *Cache) Fragmentation() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fragmentation()
+}
+
+// Compact reclaims every node left behind by lazy pruning straight away.
+func (c *Cache) Compact() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compact()
+}
+
+// compact prunes every tail node awaiting pruning.
+// The caller must hold the write lock.
+func (c *Cache) compact() {
+	c.settle()
+	for i, n := range c.unpruned {
+		c.prune(n)
+		c.unpruned[i] = nil // Don't hold on to pruned nodes
+	}
+	c.unpruned, c.settled = c.unpruned[:0], 0
+}
+
+// settle uncounts the leaves removed since the last call from the nodes above
+// them, so the count of empty nodes is up to date.
+// The caller must hold the write lock.
+func (c *Cache) settle() {
+	for _, n := range c.unpruned[c.settled:] {
+		if n.unsettled {
+			n.unsettled = false
+			c.leafRemoved(n)
+		}
+	}
+	c.settled = len(c.unpruned)
+}
+
+// reuse counts a leaf stored at the tail node n. If the removal of the leaf
+// before it is still unsettled, the two cancel out and the path is left alone.
+// The caller must hold the write lock.
+func (c *Cache) reuse(n *node) {
+	if n.unsettled {
+		n.unsettled = false
+		return
+	}
+	c.leafAdded(n)
+}
+
+// fragmentation is Fragmentation without locking, settling first.
+// The caller must hold the write lock.
+func (c *Cache) fragmentation() float64 {
+	c.settle()
+	return float64(c.emptyNodes) / float64(1+c.nodes)
+}
@@ -0,0 +1,225 @@
+package hashcache
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// walkNodes counts the nodes beneath n, and how many of them have no leaf
+// beneath them, by walking the trie.
+func walkNodes(c *Cache, n *node) (total, empty int) {
+	used := c.tails[n] != nil
+	n.each(func(_ uint64, child *node) {
+		t, e := walkNodes(c, child)
+		total += t
+		empty += e
+		used = used || e < t
+	})
+	total++
+	if !used {
+		empty++
+	}
+	return total, empty
+}
+
+// checkNodeCounts fails the test if the node counts kept by c don't match the
+// trie.
+func checkNodeCounts(t *testing.T, c *Cache, when string) {
+	t.Helper()
+	total, empty := walkNodes(c, c.head)
+	if c.head.leaves == 0 {
+		empty-- // The head is never counted as empty
+	}
+	if n := c.NodeCount(); n != total {
+		t.Fatalf("NodeCount() = %d %s, trie has %d", n, when, total)
+	}
+	if n := c.EmptyNodeCount(); n != empty {
+		t.Fatalf("EmptyNodeCount() = %d %s, trie has %d", n, when, empty)
+	}
+	if c.head.leaves != len(c.tails) {
+		t.Fatalf("head counts %d leaves %s, want %d", c.head.leaves, when, len(c.tails))
+	}
+}
+
+func TestLazyPrune(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Drive the scavenge passes by hand
+	c.SetLazyPrune(true)
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	full := c.NodeCount()
+	for i := 0; i < 100; i++ {
+		c.Delete(key(i))
+	}
+	if n := c.NodeCount(); n != full {
+		t.Errorf("NodeCount() = %d after deleting every entry lazily, want %d", n, full)
+	}
+	if f := c.Fragmentation(); f <= 0.99 {
+		t.Errorf("Fragmentation() = %v with every entry deleted, want nearly 1", f)
+	}
+	c.scavengePass(uint64(time.Now().UnixNano()/1e6), nil)
+	if n := c.NodeCount(); n != 1 {
+		t.Errorf("NodeCount() = %d after a scavenge, want just the head", n)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Delete(key(0))
+	c.Write(Row{K: key(0), V: []byte("v")}) // Reuses the unpruned nodes
+	c.Compact()
+	if n := c.NodeCount(); n != full {
+		t.Errorf("NodeCount() = %d after Compact with every entry present, want %d", n, full)
+	}
+	for i := 0; i < 50; i++ {
+		c.Delete(key(i))
+	}
+	c.SetLazyPrune(false)
+	if f := c.Fragmentation(); f != 0 {
+		t.Errorf("Fragmentation() = %v after turning lazy pruning off, want 0", f)
+	}
+	for i := 50; i < 100; i++ {
+		if _, ok := c.Read(key(i)); !ok {
+			t.Fatalf("Read(%q) missed after pruning", key(i))
+		}
+	}
+}
+
+func TestCompactThreshold(t *testing.T) {
+	c := newTestCache(t)
+	c.Close()
+	c.SetLazyPrune(true)
+	if err := c.SetCompactThreshold(1.5); err == nil {
+		t.Error("SetCompactThreshold(1.5) succeeded")
+	}
+	if err := c.SetCompactThreshold(0.5); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	now := uint64(time.Now().UnixNano() / 1e6)
+	c.Delete(key(0))
+	c.scavengePass(now, nil)
+	if f := c.Fragmentation(); f == 0 {
+		t.Error("a scavenge below the threshold reclaimed the nodes")
+	}
+	for i := 1; i < 90; i++ {
+		c.Delete(key(i))
+	}
+	c.scavengePass(now, nil)
+	if f := c.Fragmentation(); f != 0 {
+		t.Errorf("Fragmentation() = %v after a scavenge above the threshold, want 0", f)
+	}
+}
+
+func TestNodeCounts(t *testing.T) {
+	c := newTestCache(t)
+	c.Close()
+	rng := rand.New(rand.NewSource(1))
+	churn := func(n int) {
+		for i := 0; i < n; i++ {
+			k := key(rng.Intn(500))
+			switch rng.Intn(3) {
+			case 0:
+				c.Delete(k)
+			case 1:
+				c.Reserve(k, time.Minute)
+			default:
+				c.Write(Row{K: k, V: []byte("v")})
+			}
+		}
+	}
+	churn(2000)
+	checkNodeCounts(t, c, "after eager pruning")
+	c.SetLazyPrune(true)
+	churn(2000)
+	checkNodeCounts(t, c, "after lazy pruning")
+	c.SetKeyNormalizer(func(k []byte) []byte { return k[:len(k)-1] }) // Merges keys
+	checkNodeCounts(t, c, "after rehashing")
+	c.SetKeyNormalizer(nil)
+	churn(2000)
+	checkNodeCounts(t, c, "after more lazy pruning")
+	c.Compact()
+	checkNodeCounts(t, c, "after Compact")
+	if n := c.EmptyNodeCount(); n != 0 {
+		t.Errorf("EmptyNodeCount() = %d after Compact, want 0", n)
+	}
+	c.Reinitialize()
+	checkNodeCounts(t, c, "after Reinitialize")
+}
+
+func TestAutoCompaction(t *testing.T) {
+	c := newTestCache(t)
+	c.Close()
+	c.SetLazyPrune(true)
+	if err := c.SetCompactThreshold(0.3); err != nil {
+		t.Fatal(err)
+	}
+	now := uint64(time.Now().UnixNano() / 1e6)
+	for i := 0; i < 1000; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	// Churn the keys, deleting more than are written, so that fragmentation
+	// builds up across scavenges until it passes the threshold.
+	next, peak := 1000, 0.0
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 100; i++ {
+			c.Delete(key(next - 1000 + i))
+			if i%2 == 0 {
+				c.Write(Row{K: key(next + i), V: []byte("v")})
+			}
+		}
+		next += 100
+		before := c.Fragmentation()
+		c.scavengePass(now, nil)
+		after := c.Fragmentation()
+		if before > 0.3 {
+			if after != 0 {
+				t.Fatalf("round %d: Fragmentation() = %v after a scavenge above the threshold, want 0", round, after)
+			}
+			peak = before
+			break
+		}
+		if after != before {
+			t.Fatalf("round %d: scavenge below the threshold changed Fragmentation() from %v to %v", round, before, after)
+		}
+	}
+	if peak == 0 {
+		t.Fatal("churn never raised Fragmentation() above the threshold")
+	}
+	checkNodeCounts(t, c, "after auto-compaction")
+}
+
+func BenchmarkWriteDeleteLazyPrune(b *testing.B) {
+	c := benchmarkCache(b)
+	c.SetLazyPrune(true)
+	v := []byte("v")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := key(benchmarkKeys + i)
+		c.Write(Row{K: k, V: v})
+		c.Delete(k)
+	}
+}
+
+func benchmarkDelete(b *testing.B, lazy bool) {
+	c := benchmarkCache(b)
+	c.Close() // Leave any nodes to reclaim until after the timing
+	c.SetLazyPrune(lazy)
+	v := []byte("v")
+	for i := 0; i < b.N; i++ {
+		c.Write(Row{K: key(benchmarkKeys + i), V: v})
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Delete(key(benchmarkKeys + i))
+	}
+}
+
+func BenchmarkDelete(b *testing.B)          { benchmarkDelete(b, false) }
+func BenchmarkDeleteLazyPrune(b *testing.B) { benchmarkDelete(b, true) }
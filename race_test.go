@@ -0,0 +1,72 @@
+package hashcache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesSameKey(t *testing.T) {
+	c := newTestCache(t)
+	const writers = 16
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				c.Write(Row{K: []byte("k"), V: []byte(strconv.Itoa(g))})
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := c.Count(); n != 1 {
+		t.Fatalf("Count() = %d after concurrent writes of one key, want 1", n)
+	}
+	v, ok := c.Read([]byte("k"))
+	if g, err := strconv.Atoi(string(v)); !ok || err != nil || g < 0 || g >= writers {
+		t.Errorf("Read(k) = %q, %v, want the value of one of the writers", v, ok)
+	}
+	if n := c.NodeCount(); n != 1+c.Depth() {
+		t.Errorf("NodeCount() = %d for one entry, want %d", n, 1+c.Depth())
+	}
+}
+
+func TestConcurrentWritesSharedPrefix(t *testing.T) {
+	c := newTestCache(t)
+	// Every key hashes to the same path apart from the last nibble, so the
+	// writers all extend the same chain of nodes.
+	err := c.SetHashFunc(func(k []byte) uint64 {
+		i, _ := strconv.Atoi(string(k[len("key-"):]))
+		return uint64(i)<<(hashLen-bitsPerNode) | 0x0123456789abcde
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers = 1 << bitsPerNode
+	var wg sync.WaitGroup
+	for g := 0; g < writers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				c.Write(Row{K: key(g), V: []byte(strconv.Itoa(i))})
+				if i%3 == 0 {
+					c.Delete(key(g))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := c.Count(); n != writers {
+		t.Fatalf("Count() = %d after concurrent writes of %d keys, want %d", n, writers, writers)
+	}
+	for g := 0; g < writers; g++ {
+		if v, ok := c.Read(key(g)); !ok || string(v) != "199" {
+			t.Errorf("Read(%q) = %q, %v, want the last value written", key(g), v, ok)
+		}
+	}
+	if n, want := c.NodeCount(), c.Depth()+writers; n != want {
+		t.Errorf("NodeCount() = %d, want %d", n, want)
+	}
+}
@@ -0,0 +1,62 @@
+package hashcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetMaxWriteRate limits Write and WriteErr to perSecond writes per second,
+// with bursts of up to perSecond writes allowed after a quiet second.
+// Writes over the limit fail with ErrRateLimited, or wait for their turn if
+// SetBlockOnRateLimit has been called. A rate of 0 removes the limit.
+func (c *Cache) SetMaxWriteRate(perSecond int) error {
+	if perSecond < 0 {
+		return fmt.Errorf("write rate must not be negative")
+	}
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+	c.writeRate = perSecond
+	c.tokens = float64(perSecond)
+	c.tokensAt = time.Now()
+	return nil
+}
+
+// SetBlockOnRateLimit sets whether writes over the maximum write rate wait
+// until they are allowed, rather than failing with ErrRateLimited.
+func (c *Cache) SetBlockOnRateLimit(block bool) {
+	c.rateMu.Lock()
+	defer c.rateMu.Unlock()
+	c.blockOnRate = block
+}
+
+// takeWriteToken takes a token from the write rate limiter's bucket, waiting
+// for one if the bucket is empty and writes block, or returning
+// ErrRateLimited if they don't.
+func (c *Cache) takeWriteToken() error {
+	for {
+		c.rateMu.Lock()
+		if c.writeRate == 0 {
+			c.rateMu.Unlock()
+			return nil
+		}
+		now := time.Now()
+		rate := float64(c.writeRate)
+		c.tokens += now.Sub(c.tokensAt).Seconds() * rate
+		if c.tokens > rate {
+			c.tokens = rate
+		}
+		c.tokensAt = now
+		if c.tokens >= 1 {
+			c.tokens--
+			c.rateMu.Unlock()
+			return nil
+		}
+		if !c.blockOnRate {
+			c.rateMu.Unlock()
+			return ErrRateLimited
+		}
+		wait := time.Duration((1 - c.tokens) / rate * float64(time.Second))
+		c.rateMu.Unlock()
+		time.Sleep(wait)
+	}
+}
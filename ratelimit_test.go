@@ -0,0 +1,43 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxWriteRate(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetMaxWriteRate(-1); err == nil {
+		t.Error("SetMaxWriteRate accepted a negative rate")
+	}
+	if err := c.SetMaxWriteRate(10); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := c.WriteErr(Row{K: key(i), V: []byte("v")}); err != nil {
+			t.Fatalf("write %d of a burst of 10 = %v", i, err)
+		}
+	}
+	if err := c.WriteErr(Row{K: key(10), V: []byte("v")}); err != ErrRateLimited {
+		t.Errorf("write over the rate = %v, want ErrRateLimited", err)
+	}
+	if c.Has(key(10)) {
+		t.Error("a rate limited write was stored")
+	}
+
+	c.SetBlockOnRateLimit(true)
+	start := time.Now()
+	if err := c.WriteErr(Row{K: key(10), V: []byte("v")}); err != nil {
+		t.Errorf("blocking write over the rate = %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("blocking write over the rate waited %v, want about 100ms", waited)
+	}
+
+	c.SetMaxWriteRate(0)
+	for i := 0; i < 100; i++ {
+		if err := c.WriteErr(Row{K: key(i), V: []byte("v")}); err != nil {
+			t.Fatalf("write with no limit = %v", err)
+		}
+	}
+}
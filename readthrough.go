@@ -0,0 +1,75 @@
+package hashcache
+
+import (
+	"bytes"
+	"sort"
+)
+
+// prefixLoader is a loader registered for keys starting with prefix.
+type prefixLoader struct {
+	prefix []byte
+	load   func(key []byte) ([]byte, error)
+}
+
+// RegisterLoader makes a Read which misses a key starting with prefix call
+// loader for its value, without holding any lock, then write the value to the
+// cache and return it. If loader returns an error, the Read misses as normal.
+// Concurrent misses of the same key share a single call to loader, as with
+// GetOrWriteMulti, which never uses registered loaders itself.
+// When the prefixes of several loaders match a key, the longest wins.
+// Registering a loader for a prefix replaces any loader already registered
+// for it, and registering nil removes it.
+// A Read of a reserved key misses without calling loader, leaving the value to
+// whoever holds the reservation.
+// Prefixes are matched against keys after they are normalized.
+func (c *Cache) RegisterLoader(prefix []byte, loader func(key []byte) ([]byte, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	loaders := c.loaders[:0:0]
+	for _, pl := range c.loaders {
+		if !bytes.Equal(pl.prefix, prefix) {
+			loaders = append(loaders, pl)
+		}
+	}
+	if loader != nil {
+		loaders = append(loaders, prefixLoader{prefix: copyBytes(prefix), load: loader})
+		sort.SliceStable(loaders, func(i, j int) bool { return len(loaders[i].prefix) > len(loaders[j].prefix) })
+	}
+	c.loaders = loaders
+}
+
+// loaderFor returns the loader registered for key, or nil if there isn't one.
+// The caller must hold the read or write lock.
+func (c *Cache) loaderFor(key []byte) func(key []byte) ([]byte, error) {
+	if len(c.loaders) == 0 {
+		return nil
+	}
+	key = c.normalize(key)
+	for _, pl := range c.loaders {
+		if bytes.HasPrefix(key, pl.prefix) {
+			return pl.load
+		}
+	}
+	return nil
+}
+
+// readThrough loads the missing key with loader, writing it to the cache.
+func (c *Cache) readThrough(key []byte, loader func(key []byte) ([]byte, error)) ([]byte, bool) {
+	k := string(key)
+	result := map[string][]byte{}
+	err := c.loadMissing([]string{k}, func(missing [][]byte) (map[string][]byte, error) {
+		v, err := loader(missing[0])
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{k: v}, nil
+	}, result)
+	v, ok := result[k]
+	if err != nil || !ok {
+		return nil, false
+	}
+	if v == nil {
+		v = []byte{} // As Read returns for a stored nil value
+	}
+	return v, true
+}
@@ -0,0 +1,101 @@
+package hashcache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegisterLoader(t *testing.T) {
+	c := newTestCache(t)
+	loader := func(name string) func(key []byte) ([]byte, error) {
+		return func(key []byte) ([]byte, error) {
+			if string(key) == "user:missing" {
+				return nil, errors.New("not found")
+			}
+			return []byte(name + " " + string(key)), nil
+		}
+	}
+	c.RegisterLoader([]byte("user:"), loader("user"))
+	c.RegisterLoader([]byte("user:admin:"), loader("admin"))
+	for k, want := range map[string]string{
+		"user:1":       "user user:1",
+		"user:admin:1": "admin user:admin:1",
+	} {
+		if v, ok := c.Read([]byte(k)); !ok || string(v) != want {
+			t.Errorf("Read(%q) = %q, %v, want %q", k, v, ok, want)
+		}
+		if !c.Has([]byte(k)) {
+			t.Errorf("loaded value of %q wasn't written", k)
+		}
+	}
+	for _, k := range []string{"user:missing", "other:1"} {
+		if v, ok := c.Read([]byte(k)); ok {
+			t.Errorf("Read(%q) = %q, want a miss", k, v)
+		}
+	}
+
+	c.RegisterLoader([]byte("user:"), loader("replaced"))
+	if v, _ := c.Read([]byte("user:2")); string(v) != "replaced user:2" {
+		t.Errorf("Read(user:2) = %q after replacing the loader", v)
+	}
+	c.RegisterLoader([]byte("user:admin:"), nil)
+	if v, _ := c.Read([]byte("user:admin:2")); string(v) != "replaced user:admin:2" {
+		t.Errorf("Read(user:admin:2) = %q after removing the longer prefix", v)
+	}
+	c.RegisterLoader([]byte("user:"), nil)
+	if _, ok := c.Read([]byte("user:3")); ok {
+		t.Error("Read(user:3) hit after removing every loader")
+	}
+}
+
+func TestRegisterLoaderLeavesReservations(t *testing.T) {
+	c := newTestCache(t)
+	var calls int32
+	c.RegisterLoader([]byte("k"), func(key []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("loaded"), nil
+	})
+	if !c.Reserve([]byte("k"), time.Minute) {
+		t.Fatal("Reserve(k) = false")
+	}
+	if v, ok := c.Read([]byte("k")); ok {
+		t.Errorf("Read(k) = %q of a reserved key, want a miss", v)
+	}
+	if n := atomic.LoadInt32(&calls); n != 0 {
+		t.Errorf("loader called %d times for a reserved key", n)
+	}
+	if _, reserved, ok := c.ReadOrReserved([]byte("k")); !reserved || !ok {
+		t.Error("reservation of k was overwritten by the loader")
+	}
+}
+
+func TestRegisterLoaderSharesLoads(t *testing.T) {
+	c := newTestCache(t)
+	var calls int32
+	release := make(chan struct{})
+	c.RegisterLoader([]byte("k"), func(key []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []byte("v"), nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, ok := c.Read([]byte("k")); !ok || string(v) != "v" {
+				t.Errorf("Read(k) = %q, %v", v, ok)
+			}
+		}()
+	}
+	waitFor(t, "the load to start", func() bool { return atomic.LoadInt32(&calls) == 1 })
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("loader called %d times, want 1", n)
+	}
+}
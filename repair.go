@@ -0,0 +1,69 @@
+package hashcache
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetReadRepair makes a Read which finds an entry within window milliseconds
+// of expiring fetch a fresh value for it from fallback in the background,
+// writing it to the cache if fallback doesn't return an error.
+// At most concurrency repairs run at once, and a key is never repaired twice
+// at once. Reads which would start more are served as normal without a
+// repair, so the fallback is never sent more than concurrency requests.
+// Passing a nil fallback disables read repair, ignoring window and concurrency.
+func (c *Cache) SetReadRepair(fallback func(key []byte) ([]byte, error), window uint64, concurrency int) error {
+	if fallback != nil && concurrency < 1 {
+		return fmt.Errorf("read repair concurrency must be at least 1")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.repairFn = fallback
+	c.repairWindow = window
+	c.repairSlots = nil
+	if fallback != nil {
+		c.repairSlots = make(chan struct{}, concurrency)
+	}
+	return nil
+}
+
+// repair starts a background refresh of l if it is close to expiring, there
+// is a free repair slot, and l isn't already being repaired.
+// It must be called under the read or write lock, before touch.
+func (c *Cache) repair(l *leaf) {
+	if c.repairFn == nil {
+		return
+	}
+	now := uint64(time.Now().UnixNano() / 1e6)
+	if now+c.repairWindow < c.deadline(l) {
+		return
+	}
+	key := copyBytes(l.key)
+	c.repairMu.Lock()
+	defer c.repairMu.Unlock()
+	if c.repairing[string(key)] {
+		return
+	}
+	slots := c.repairSlots
+	select {
+	case slots <- struct{}{}:
+	default:
+		return
+	}
+	c.repairing[string(key)] = true
+	fn := c.repairFn
+	go func() {
+		defer func() {
+			c.repairMu.Lock()
+			delete(c.repairing, string(key))
+			c.repairMu.Unlock()
+			<-slots
+		}()
+		start := time.Now()
+		v, err := fn(key)
+		c.timeCallback("read repair", start)
+		if err == nil {
+			c.Write(Row{K: key, V: v})
+		}
+	}()
+}
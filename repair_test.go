@@ -0,0 +1,88 @@
+package hashcache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadRepair(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetTTL(1000); err != nil {
+		t.Fatal(err)
+	}
+	var calls int32
+	err := c.SetReadRepair(func(key []byte) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		return []byte("repaired"), nil
+	}, 500, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WriteWithTimer([]byte("fresh"), []byte("v"), time.Minute, nil)
+	c.WriteWithTimer([]byte("expiring"), []byte("v"), 100*time.Millisecond, nil)
+	if v, _ := c.Read([]byte("fresh")); string(v) != "v" {
+		t.Fatalf("Read(fresh) = %q", v)
+	}
+	if v, _ := c.Read([]byte("expiring")); string(v) != "v" {
+		t.Errorf("Read of an entry being repaired = %q, want the old value", v)
+	}
+	waitFor(t, "the repair", func() bool {
+		v, _ := c.Read([]byte("expiring"))
+		return string(v) == "repaired"
+	})
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("fallback called %d times, want 1", n)
+	}
+}
+
+func TestReadRepairConcurrency(t *testing.T) {
+	c := newTestCache(t)
+	release := make(chan struct{})
+	var running, most int32
+	var mu sync.Mutex
+	err := c.SetReadRepair(func(key []byte) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		mu.Lock()
+		if n > most {
+			most = n
+		}
+		mu.Unlock()
+		<-release
+		atomic.AddInt32(&running, -1)
+		return []byte("repaired"), nil
+	}, 20000, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	for j := 0; j < 3; j++ {
+		for i := 0; i < 5; i++ {
+			c.Read(key(i))
+		}
+	}
+	waitFor(t, "repairs to start", func() bool { return atomic.LoadInt32(&running) == 2 })
+	close(release)
+	waitFor(t, "repairs to finish", func() bool { return atomic.LoadInt32(&running) == 0 })
+	mu.Lock()
+	defer mu.Unlock()
+	if most != 2 {
+		t.Errorf("%d repairs ran at once, want 2", most)
+	}
+}
+
+func TestSetReadRepairValidation(t *testing.T) {
+	c := newTestCache(t)
+	fallback := func(key []byte) ([]byte, error) { return nil, nil }
+	if err := c.SetReadRepair(fallback, 100, 0); err == nil {
+		t.Error("SetReadRepair accepted a concurrency of 0")
+	}
+	if err := c.SetReadRepair(nil, 0, -1); err != nil {
+		t.Errorf("SetReadRepair(nil, 0, -1) = %v", err)
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("v")})
+	c.Read([]byte("k"))
+}
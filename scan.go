@@ -0,0 +1,50 @@
+package hashcache
+
+// Scan returns up to limit entries, starting from cursor, and the cursor to
+// pass to the next call, or 0 once every entry has been returned. A scan
+// starts with a cursor of 0.
+// Like Redis's SCAN, entries are returned in the order of their hashes rather
+// than the order they were written, so a scan spread over many calls
+// tolerates the cache changing between them: an entry present for the whole
+// scan is returned exactly once, while one written or removed during the scan
+// may or may not be. Rotating the salt, or changing the hash function or key
+// normalizer, reorders the entries and invalidates any cursor.
+// Reservations aren't included. Each call holds the read lock only while it
+// collects its entries.
+func (c *Cache) Scan(cursor uint64, limit int) (entries []EntryInfo, next uint64) {
+	if limit <= 0 {
+		return nil, cursor
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	// The position of an entry is its path through the trie, read as a
+	// number from the head down, so positions increase in walk order.
+	var walk func(n *node, depth int, pos uint64) bool
+	walk = func(n *node, depth int, pos uint64) bool {
+		if depth == hashLen/bitsPerNode {
+			l := c.tails[n]
+			if l == nil || l.reserved {
+				return true
+			}
+			if len(entries) == limit {
+				next = pos
+				return false
+			}
+			entries = append(entries, c.info(l))
+			return true
+		}
+		shift := uint(hashLen - bitsPerNode*(depth+1))
+		for i := uint64(0); i < 1<<bitsPerNode; i++ {
+			p := pos | i<<shift
+			if p|(1<<shift-1) < cursor {
+				continue // Everything beneath this child comes before the cursor
+			}
+			if child := n.child(i); child != nil && !walk(child, depth+1, p) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(c.head, 0, 0)
+	return entries, next
+}
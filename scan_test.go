@@ -0,0 +1,52 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	c := newTestCache(t)
+	for i := 0; i < 1000; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.Reserve([]byte("reserved"), time.Minute)
+	seen := map[string]int{}
+	cursor, calls := uint64(0), 0
+	for {
+		entries, next := c.Scan(cursor, 7)
+		if len(entries) > 7 {
+			t.Fatalf("Scan returned %d entries with a limit of 7", len(entries))
+		}
+		for _, e := range entries {
+			seen[string(e.Key)]++
+		}
+		// Change the cache between calls: keys 900 and up come and go.
+		c.Delete(key(900 + calls%100))
+		c.Write(Row{K: key(1000 + calls), V: []byte("v")})
+		calls++
+		if next == 0 {
+			break
+		}
+		if next <= cursor {
+			t.Fatalf("Scan cursor went from %d to %d", cursor, next)
+		}
+		cursor = next
+	}
+	for i := 0; i < 900; i++ {
+		if n := seen[string(key(i))]; n != 1 {
+			t.Errorf("%q, present for the whole scan, returned %d times", key(i), n)
+		}
+	}
+	for k, n := range seen {
+		if n > 1 {
+			t.Errorf("%q returned %d times", k, n)
+		}
+	}
+	if seen["reserved"] != 0 {
+		t.Error("Scan returned a reservation")
+	}
+	if entries, next := c.Scan(42, 0); entries != nil || next != 42 {
+		t.Errorf("Scan(42, 0) = %d entries, %d, want none, 42", len(entries), next)
+	}
+}
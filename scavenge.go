@@ -0,0 +1,52 @@
+package hashcache
+
+import "fmt"
+
+// ScavengeMode sets how thoroughly each scavenge looks for expired entries.
+type ScavengeMode int
+
+const (
+	// Thorough checks every entry on each scavenge, removing all expired
+	// entries promptly but holding the write lock for a time proportional
+	// to the size of the cache. This is the default.
+	Thorough ScavengeMode = iota
+	// LowLatency checks a limited number of entries on each scavenge,
+	// carrying on from where the last one stopped. This bounds how long the
+	// write lock is held, at the cost of expired entries lingering until the
+	// scavenger gets round to them.
+	LowLatency
+)
+
+// SetScavengeMode sets how each scavenge looks for expired entries.
+// In LowLatency mode, budget is the number of entries checked per scavenge,
+// and must be at least 1. It is ignored in Thorough mode.
+func (c *Cache) SetScavengeMode(mode ScavengeMode, budget int) error {
+	if mode == LowLatency && budget < 1 {
+		return fmt.Errorf("scavenge budget must be at least 1")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scavengeMode = mode
+	c.budget = budget
+	return nil
+}
+
+// scavengeSome checks up to the scavenge budget of leaves for expiry, starting
+// where the last call stopped, and wrapping round at the end of the leaves.
+// Removing a leaf moves scavengeNext past it, so it never points at a
+// removed leaf. It stops early once the batch of expiry callbacks is full,
+// leaving the next leaf for the pass which follows. The caller must hold the
+// write lock.
+func (c *Cache) scavengeSome(now uint64) {
+	if c.scavengeNext == nil {
+		c.scavengeNext = c.start
+	}
+	for i := 0; c.scavengeNext != nil && i < c.budget && len(c.fired) < expiryBatch; i++ {
+		l := c.scavengeNext
+		c.scavengeNext = l.next
+		c.examined++
+		if c.expired(l, now) {
+			c.expire(l.tail)
+		}
+	}
+}
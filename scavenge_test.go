@@ -0,0 +1,54 @@
+package hashcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLowLatencyScavenge(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeMode(LowLatency, 0); err == nil {
+		t.Error("SetScavengeMode accepted a budget of 0")
+	}
+	if err := c.SetScavengeMode(LowLatency, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetTTL(20); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	waitFor(t, "every entry to expire", func() bool { return c.Count() == 0 })
+}
+
+func TestScavengeSomeBudget(t *testing.T) {
+	c := newTestCache(t)
+	c.SetScavengeMode(LowLatency, 2)
+	for i := 0; i < 5; i++ {
+		c.Write(Row{K: key(i), V: []byte("v")})
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := uint64(time.Now().UnixNano()/1e6) + c.ttl + 1 // Past every entry's TTL
+	c.scavengeSome(now)
+	if n := len(c.tails); n != 3 {
+		t.Fatalf("%d entries left after scavenging with a budget of 2, want 3", n)
+	}
+	// Removing the leaf the next scavenge starts from moves it on.
+	next := c.scavengeNext
+	c.deleteNode(next.tail)
+	if c.scavengeNext == next || c.scavengeNext == nil {
+		t.Fatal("scavengeNext wasn't moved past the removed leaf")
+	}
+	c.scavengeSome(now)
+	if n := len(c.tails); n != 0 {
+		t.Errorf("%d entries left, want 0", n)
+	}
+	if c.scavengeNext != nil {
+		t.Error("scavengeNext isn't nil at the end of the leaves")
+	}
+}
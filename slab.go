@@ -0,0 +1,85 @@
+package hashcache
+
+import (
+	"bytes"
+
+	"github.com/dchest/siphash"
+)
+
+// slabValue is a value shared by every entry holding the same bytes.
+type slabValue struct {
+	value []byte
+	refs  int
+}
+
+// SetValueDedup sets whether values are deduplicated. When enabled, values
+// are kept in a separate table keyed by a hash of their contents, and entries
+// whose values are equal share a single, reference counted copy. This saves
+// memory when many keys hold the same value, at the cost of hashing every
+// value written. Enabling it deduplicates the existing values.
+func (c *Cache) SetValueDedup(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case enabled && c.slab == nil:
+		c.slab = map[uint64][]*slabValue{}
+		for _, l := range c.tails {
+			v := c.intern(*l.valuePointer)
+			l.valuePointer = &v
+			l.interned = true
+		}
+	case !enabled && c.slab != nil:
+		c.slab = nil
+		for _, l := range c.tails {
+			l.interned = false
+		}
+	}
+}
+
+// intern returns the shared copy of v, adding it to the slab if it is new.
+// The caller must hold the write lock.
+func (c *Cache) intern(v []byte) []byte {
+	h := siphash.Hash(0, 0, v)
+	for _, s := range c.slab[h] {
+		if bytes.Equal(s.value, v) {
+			s.refs++
+			return s.value
+		}
+	}
+	s := &slabValue{value: copyBytes(v), refs: 1}
+	c.slab[h] = append(c.slab[h], s)
+	return s.value
+}
+
+// unintern drops a reference to the shared copy of v, removing it from the
+// slab once nothing refers to it. The caller must hold the write lock.
+func (c *Cache) unintern(v []byte) {
+	h := siphash.Hash(0, 0, v)
+	values := c.slab[h]
+	for i, s := range values {
+		if !bytes.Equal(s.value, v) {
+			continue
+		}
+		if s.refs--; s.refs > 0 {
+			return
+		}
+		values = append(values[:i], values[i+1:]...)
+		if len(values) == 0 {
+			delete(c.slab, h)
+		} else {
+			c.slab[h] = values
+		}
+		return
+	}
+}
+
+// slabRefs returns the number of entries sharing the copy of v in the slab.
+// The caller must hold the read or write lock.
+func (c *Cache) slabRefs(v []byte) int {
+	for _, s := range c.slab[siphash.Hash(0, 0, v)] {
+		if bytes.Equal(s.value, v) {
+			return s.refs
+		}
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package hashcache
+
+import "testing"
+
+func TestValueDedup(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("before"), V: []byte("shared")})
+	c.SetValueDedup(true)
+	c.Write(Row{K: []byte("a"), V: []byte("shared")})
+	c.Write(Row{K: []byte("b"), V: []byte("shared")})
+	c.Write(Row{K: []byte("c"), V: []byte("other")})
+	c.mu.RLock()
+	a, b, before := c.find([]byte("a")), c.find([]byte("b")), c.find([]byte("before"))
+	if &(*a.valuePointer)[0] != &(*b.valuePointer)[0] || &(*a.valuePointer)[0] != &(*before.valuePointer)[0] {
+		t.Error("equal values aren't shared")
+	}
+	refs := c.slabRefs([]byte("shared"))
+	c.mu.RUnlock()
+	if refs != 3 {
+		t.Errorf("shared value has %d references, want 3", refs)
+	}
+
+	c.Delete([]byte("a"))
+	c.Write(Row{K: []byte("b"), V: []byte("changed")})
+	c.mu.RLock()
+	refs = c.slabRefs([]byte("shared"))
+	c.mu.RUnlock()
+	if refs != 1 {
+		t.Errorf("shared value has %d references after removing two, want 1", refs)
+	}
+	c.Delete([]byte("before"))
+	c.mu.RLock()
+	values := len(c.slab)
+	c.mu.RUnlock()
+	if values != 2 {
+		t.Errorf("slab holds %d values, want 2", values)
+	}
+	if v, _ := c.Read([]byte("b")); string(v) != "changed" {
+		t.Errorf("Read(b) = %q, want changed", v)
+	}
+
+	c.SetValueDedup(false)
+	c.Write(Row{K: []byte("d"), V: []byte("changed")})
+	c.Delete([]byte("b"))
+	if v, _ := c.Read([]byte("d")); string(v) != "changed" {
+		t.Errorf("Read(d) after disabling dedup = %q, want changed", v)
+	}
+}
@@ -0,0 +1,28 @@
+package hashcache
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// SetSlowCallbackThreshold sets how long a loader, read repair fallback,
+// expiry callback or memory pressure handler may run before the cache logs a
+// warning about it with the standard logger. This helps find a slow callback
+// which is holding up the goroutine calling it. A threshold of 0, the default,
+// disables the check.
+func (c *Cache) SetSlowCallbackThreshold(d time.Duration) {
+	atomic.StoreInt64(&c.slowCallback, int64(d))
+}
+
+// timeCallback logs a warning if the callback described by name, which was
+// called at start, took longer than the slow callback threshold.
+func (c *Cache) timeCallback(name string, start time.Time) {
+	threshold := time.Duration(atomic.LoadInt64(&c.slowCallback))
+	if threshold <= 0 {
+		return
+	}
+	if d := time.Since(start); d > threshold {
+		log.Printf("hashcache: slow %s callback took %v", name, d)
+	}
+}
@@ -0,0 +1,37 @@
+package hashcache
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowCallbackLogging(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+	c := newTestCache(t)
+	c.RegisterLoader([]byte("slow"), func(key []byte) ([]byte, error) {
+		time.Sleep(20 * time.Millisecond)
+		return []byte("v"), nil
+	})
+	c.RegisterLoader([]byte("fast"), func(key []byte) ([]byte, error) {
+		return []byte("v"), nil
+	})
+
+	c.Read([]byte("slow-0"))
+	if buf.Len() != 0 {
+		t.Errorf("logged %q with no threshold set", buf.String())
+	}
+	c.SetSlowCallbackThreshold(10 * time.Millisecond)
+	c.Read([]byte("fast-0"))
+	if buf.Len() != 0 {
+		t.Errorf("logged %q for a fast loader", buf.String())
+	}
+	c.Read([]byte("slow-1"))
+	if !strings.Contains(buf.String(), "hashcache: slow loader callback took") {
+		t.Errorf("logged %q for a slow loader", buf.String())
+	}
+}
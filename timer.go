@@ -0,0 +1,22 @@
+package hashcache
+
+import "time"
+
+// WriteWithTimer will add the key and value to the cache, like Write, with
+// its own TTL, and arrange for onExpire to be called with the value when the
+// entry expires. A ttl of less than 1 millisecond uses the cache TTL, or never
+// expires if SetZeroTTLNeverExpires is on.
+// The callback runs on the scavenger after it releases the lock, so it fires
+// up to the scavenge time after the TTL runs out, and a slow callback delays
+// the next scavenge. Overwriting or deleting the key, or evicting it to make
+// room, cancels the callback.
+func (c *Cache) WriteWithTimer(key, value []byte, ttl time.Duration, onExpire func(value []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, err := c.insert(Row{K: key, V: value})
+	if err != nil {
+		return
+	}
+	l.ttl = c.leafTTL(ttl)
+	l.onExpire = onExpire
+}
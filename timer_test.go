@@ -0,0 +1,161 @@
+package hashcache
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteWithTimer(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(5); err != nil {
+		t.Fatal(err)
+	}
+	fired := make(chan string, 10)
+	onExpire := func(v []byte) { fired <- string(v) }
+	c.WriteWithTimer([]byte("expires"), []byte("first"), 20*time.Millisecond, onExpire)
+	c.WriteWithTimer([]byte("overwritten"), []byte("v"), 20*time.Millisecond, onExpire)
+	c.WriteWithTimer([]byte("deleted"), []byte("v"), 20*time.Millisecond, onExpire)
+	c.WriteWithTimer([]byte("expires"), []byte("final"), 20*time.Millisecond, onExpire)
+	c.Write(Row{K: []byte("overwritten"), V: []byte("w")})
+	c.Delete([]byte("deleted"))
+	select {
+	case v := <-fired:
+		if v != "final" {
+			t.Errorf("callback got %q, want the final value", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("callback didn't fire")
+	}
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case v := <-fired:
+		t.Errorf("cancelled callback fired with %q", v)
+	default:
+	}
+}
+
+func TestExpiryCallbacksBatched(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Drive the scavenge passes by hand
+	var mu sync.Mutex
+	fired := 0
+	onExpire := func([]byte) {
+		mu.Lock()
+		fired++
+		mu.Unlock()
+	}
+	const entries = 2*expiryBatch + 10
+	for i := 0; i < entries; i++ {
+		c.WriteWithTimer(key(i), []byte("v"), time.Millisecond, onExpire)
+	}
+	c.Write(Row{K: []byte("no callback"), V: []byte("v")})
+	c.WriteWithTimer([]byte("no callback either"), []byte("v"), time.Millisecond, nil)
+	now := uint64(time.Now().Add(time.Minute).UnixNano() / 1e6)
+	buf := make([]func(), 0, expiryBatch)
+	for pass, want := range []int{expiryBatch, expiryBatch, 10, 0} {
+		callbacks, _ := c.scavengePass(now, buf)
+		if len(callbacks) != want {
+			t.Fatalf("pass %d queued %d callbacks, want %d", pass, len(callbacks), want)
+		}
+		if cap(callbacks) != expiryBatch {
+			t.Fatalf("pass %d grew the callback buffer to %d", pass, cap(callbacks))
+		}
+		for _, f := range callbacks {
+			f()
+		}
+		buf = callbacks[:0]
+	}
+	if fired != entries {
+		t.Errorf("%d callbacks fired, want %d", fired, entries)
+	}
+	if n := c.Count(); n != 0 {
+		t.Errorf("Count() = %d after every entry expired", n)
+	}
+}
+
+func TestExpiryCallbacksBurst(t *testing.T) {
+	c := newTestCache(t)
+	if err := c.SetScavengeTime(50); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	fired := 0
+	const entries = 5 * expiryBatch
+	for i := 0; i < entries; i++ {
+		c.WriteWithTimer(key(i), []byte("v"), 10*time.Millisecond, func([]byte) {
+			mu.Lock()
+			fired++
+			mu.Unlock()
+		})
+	}
+	// Without the follow up passes this would take 5 scavenge times.
+	start := time.Now()
+	waitFor(t, "every callback to fire", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return fired == entries
+	})
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("callbacks took %v to fire, want the batches to follow one another", d)
+	}
+}
+
+// TestExpiryBurstExaminesOnce checks that a burst of expiring entries takes
+// one pass per batch, with each entry examined by only one of them.
+func TestExpiryBurstExaminesOnce(t *testing.T) {
+	c := newTestCache(t)
+	c.Close() // Drive the scavenge passes by hand
+	const entries = 16 * expiryBatch
+	for i := 0; i < entries; i++ {
+		c.WriteWithTimer(key(i), []byte("v"), time.Millisecond, func([]byte) {})
+	}
+	now := uint64(time.Now().Add(time.Minute).UnixNano() / 1e6)
+	buf := make([]func(), 0, expiryBatch)
+	passes, examined := 0, 0
+	for {
+		callbacks, _ := c.scavengePass(now, buf)
+		passes++
+		examined += c.examined
+		if len(callbacks) < expiryBatch {
+			break
+		}
+		buf = callbacks[:0]
+	}
+	if want := entries/expiryBatch + 1; passes != want {
+		t.Errorf("burst took %d passes, want %d", passes, want)
+	}
+	if examined != entries {
+		t.Errorf("burst examined %d entries, want %d", examined, entries)
+	}
+	if n := c.Count(); n != 0 {
+		t.Errorf("Count() = %d after every entry expired", n)
+	}
+}
+
+// TestExpiryBurstAllocation checks that the memory a scavenge allocates for
+// expiry callbacks doesn't grow with the number of entries expiring at once.
+func TestExpiryBurstAllocation(t *testing.T) {
+	mallocs := func(entries int) uint64 {
+		c := newTestCache(t)
+		c.Close() // Drive the scavenge pass by hand
+		for i := 0; i < entries; i++ {
+			c.WriteWithTimer(key(i), []byte("v"), time.Millisecond, func([]byte) {})
+		}
+		now := uint64(time.Now().Add(time.Minute).UnixNano() / 1e6)
+		buf := make([]func(), 0, expiryBatch)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		callbacks, _ := c.scavengePass(now, buf)
+		runtime.ReadMemStats(&after)
+		if len(callbacks) != expiryBatch {
+			t.Fatalf("pass queued %d callbacks, want %d", len(callbacks), expiryBatch)
+		}
+		return after.Mallocs - before.Mallocs
+	}
+	small, large := mallocs(2*expiryBatch), mallocs(32*expiryBatch)
+	if large > small+small/2 {
+		t.Errorf("a pass made %d allocations with 16 times as many entries expiring, against %d", large, small)
+	}
+}
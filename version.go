@@ -0,0 +1,21 @@
+package hashcache
+
+// WriteVersioned will add the key and value to the cache, like Write, but
+// only if version is greater than the version stored with the key, so that
+// updates arriving out of order can't replace a newer value with an older one.
+// A missing or reserved key accepts any version, and a key last written by
+// anything other than WriteVersioned has version 0.
+// It returns whether the value was written.
+func (c *Cache) WriteVersioned(key, value []byte, version uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := c.find(key); l != nil && !l.reserved && version <= l.version {
+		return false
+	}
+	l, err := c.insert(Row{K: key, V: value})
+	if err != nil {
+		return false
+	}
+	l.version = version
+	return true
+}
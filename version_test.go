@@ -0,0 +1,56 @@
+package hashcache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestWriteVersioned(t *testing.T) {
+	c := newTestCache(t)
+	k := []byte("k")
+	for _, tt := range []struct {
+		version uint64
+		value   string
+		want    bool
+	}{
+		{2, "v2", true},
+		{1, "v1", false},
+		{2, "v2 again", false},
+		{5, "v5", true},
+	} {
+		if got := c.WriteVersioned(k, []byte(tt.value), tt.version); got != tt.want {
+			t.Errorf("WriteVersioned(%d) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+	if v, _ := c.Read(k); string(v) != "v5" {
+		t.Errorf("Read(k) = %q, want %q", v, "v5")
+	}
+	c.Write(Row{K: k, V: []byte("plain")})
+	if !c.WriteVersioned(k, []byte("v1"), 1) {
+		t.Error("WriteVersioned(1) after a plain Write = false")
+	}
+	c.Delete(k)
+	if !c.WriteVersioned(k, []byte("v0"), 0) {
+		t.Error("WriteVersioned(0) of a deleted key = false")
+	}
+	c.Reserve([]byte("reserved"), 0)
+	if !c.WriteVersioned([]byte("reserved"), []byte("v"), 0) {
+		t.Error("WriteVersioned(0) of a reserved key = false")
+	}
+}
+
+func TestWriteVersionedOutOfOrder(t *testing.T) {
+	c := newTestCache(t)
+	var wg sync.WaitGroup
+	for v := uint64(1); v <= 100; v++ {
+		wg.Add(1)
+		go func(v uint64) {
+			defer wg.Done()
+			c.WriteVersioned([]byte("k"), key(int(v)), v)
+		}(v)
+	}
+	wg.Wait()
+	if v, _ := c.Read([]byte("k")); string(v) != string(key(100)) {
+		t.Errorf("Read(k) = %q after concurrent writes, want the newest %q", v, key(100))
+	}
+}
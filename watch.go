@@ -0,0 +1,77 @@
+package hashcache
+
+import "sync"
+
+// watchBuffer is the number of values a watcher can fall behind by before
+// new values are dropped for it.
+const watchBuffer = 8
+
+// Watch returns a channel which receives the value of key each time it is
+// written, and a function which stops the watch and closes the channel.
+// If key already has a value, it is sent on the channel straight away.
+// Sends never block a write, so a watcher which falls more than a few values
+// behind misses the values written in the meantime.
+// Deleting or expiring the key sends nothing.
+func (c *Cache) Watch(key []byte) (<-chan []byte, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan []byte, watchBuffer)
+	k := string(c.normalize(key))
+	c.watchers[k] = append(c.watchers[k], ch)
+	if l := c.find(key); l != nil && !l.reserved {
+		ch <- copyBytes(*l.valuePointer)
+	}
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.unwatch(ch)
+			close(ch)
+		})
+	}
+	return ch, cancel
+}
+
+// unwatch removes the watcher ch. It looks through every key, as ch may have
+// moved to another key since it was registered if the key normalizer changed.
+// The caller must hold the write lock.
+func (c *Cache) unwatch(ch chan []byte) {
+	for k, watchers := range c.watchers {
+		for i, w := range watchers {
+			if w != ch {
+				continue
+			}
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			if len(watchers) == 0 {
+				delete(c.watchers, k)
+			} else {
+				c.watchers[k] = watchers
+			}
+			return
+		}
+	}
+}
+
+// rekeyWatchers moves every watcher to its key passed through fn, merging the
+// watchers of keys which fn maps to the same bytes.
+// The caller must hold the write lock.
+func (c *Cache) rekeyWatchers(fn func([]byte) []byte) {
+	watchers := make(map[string][]chan []byte, len(c.watchers))
+	for k, ws := range c.watchers {
+		n := string(fn([]byte(k)))
+		watchers[n] = append(watchers[n], ws...)
+	}
+	c.watchers = watchers
+}
+
+// notify sends the value of l to any watchers of its key, without blocking.
+// The caller must hold the write lock.
+func (c *Cache) notify(l *leaf) {
+	for _, ch := range c.watchers[string(l.key)] {
+		select {
+		case ch <- copyBytes(*l.valuePointer):
+		default:
+		}
+	}
+}
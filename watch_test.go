@@ -0,0 +1,70 @@
+package hashcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	c := newTestCache(t)
+	c.Write(Row{K: []byte("k"), V: []byte("first")})
+	ch, cancel := c.Watch([]byte("k"))
+	for _, v := range []string{"second", "third"} {
+		c.Write(Row{K: []byte("k"), V: []byte(v)})
+	}
+	c.Write(Row{K: []byte("other"), V: []byte("v")})
+	c.Delete([]byte("k"))
+	for _, want := range []string{"first", "second", "third"} {
+		select {
+		case v := <-ch:
+			if string(v) != want {
+				t.Errorf("watcher got %q, want %q", v, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("watcher didn't get %q", want)
+		}
+	}
+	select {
+	case v := <-ch:
+		t.Errorf("watcher got %q after the last write", v)
+	default:
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after cancel")
+	}
+	c.Write(Row{K: []byte("k"), V: []byte("v")}) // Mustn't send on the closed channel
+}
+
+func TestWatchSlowWatcher(t *testing.T) {
+	c := newTestCache(t)
+	ch, cancel := c.Watch([]byte("k"))
+	defer cancel()
+	for i := 0; i < watchBuffer*2; i++ {
+		c.Write(Row{K: []byte("k"), V: key(i)})
+	}
+	if n := len(ch); n != watchBuffer {
+		t.Errorf("watcher has %d values waiting, want %d", n, watchBuffer)
+	}
+}
+
+func TestWatchKeyNormalizer(t *testing.T) {
+	c := newTestCache(t)
+	ch, cancel := c.Watch([]byte("Key"))
+	c.SetKeyNormalizer(bytes.ToLower)
+	c.Write(Row{K: []byte("KEY"), V: []byte("v")})
+	select {
+	case v := <-ch:
+		if string(v) != "v" {
+			t.Errorf("watcher got %q, want %q", v, "v")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher registered before SetKeyNormalizer missed a write")
+	}
+	cancel()
+	if n := len(c.watchers); n != 0 {
+		t.Errorf("%d keys still watched after cancel", n)
+	}
+}